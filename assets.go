@@ -0,0 +1,8 @@
+package main
+
+import "embed"
+
+// assets are the templates, the message catalogs and the static files bundled into the binary
+//
+//go:embed tmpl frontend img locales
+var assets embed.FS
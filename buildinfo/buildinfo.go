@@ -0,0 +1,40 @@
+// Package buildinfo holds the version information injected at build time, e.g.
+//
+//	go build -ldflags "-X tinywebserver/buildinfo.Commit=$(git rev-parse HEAD) -X tinywebserver/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version, Commit and BuildTime are overwritten with -ldflags "-X ..." during the build
+var (
+	Version   = ""
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build information, if the version wasn't injected the module version is used
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+	if len(info.Version) == 0 {
+		info.Version = "(devel)"
+		if moduleInfo, ok := debug.ReadBuildInfo(); ok && len(moduleInfo.Main.Version) > 0 {
+			info.Version = moduleInfo.Main.Version
+		}
+	}
+	return info
+}
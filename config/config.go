@@ -0,0 +1,562 @@
+// Package config loads the server configuration. Values are layered, every layer overrides the
+// previous one: defaults, the YAML file, TWS_* environment variables and command line flags.
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"tinywebserver/logger"
+)
+
+// DefaultPath is the config file used when neither -config nor TWS_CONFIG is set
+const DefaultPath = "config/config.yml"
+
+const envPrefix = "TWS_"
+
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Templates   TemplatesConfig   `yaml:"templates"`
+	Assets      AssetsConfig      `yaml:"assets"`
+	Session     SessionConfig     `yaml:"session"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Security    SecurityConfig    `yaml:"security"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Compression CompressionConfig `yaml:"compression"`
+	I18n        I18nConfig        `yaml:"i18n"`
+	Github      GithubConfig      `yaml:"github"`
+	Log         LogConfig         `yaml:"log"`
+	// Debug shows error details and panic stack traces on the error pages
+	Debug bool `yaml:"debug"`
+
+	// Deprecated keys of the flat config file, they are copied into Github when it's empty
+	LegacyGithubCID  string `yaml:"auth_github_cid"`
+	LegacyGithubCSec string `yaml:"auth_github_csec"`
+}
+
+type ServerConfig struct {
+	// Addr is the TCP address the server listens on, e.g. ":8080"
+	Addr           string        `yaml:"addr"`
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes int           `yaml:"max_header_bytes"`
+	// ShutdownDrain is the time /readyz reports not ready after SIGINT or SIGTERM before the listeners
+	// close, so the load balancers stop routing new requests to the instance
+	ShutdownDrain time.Duration `yaml:"shutdown_drain"`
+	// ShutdownTimeout is the time given to in-flight requests to finish after the drain
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type DatabaseConfig struct {
+	Path   string       `yaml:"path"`
+	Backup BackupConfig `yaml:"backup"`
+}
+
+// BackupConfig enables the scheduled backups of the database when both of Dir and Interval are set
+type BackupConfig struct {
+	Dir      string        `yaml:"dir"`
+	Interval time.Duration `yaml:"interval"`
+	// Keep is the number of the newest backups kept in Dir, zero keeps all of them
+	Keep int `yaml:"keep"`
+}
+
+// Enabled reports whether the backups are made on schedule
+func (cfg BackupConfig) Enabled() bool {
+	return len(cfg.Dir) > 0 && cfg.Interval > 0
+}
+
+type TemplatesConfig struct {
+	// Path is the directory of the templates inside of the assets, all of the *.html files under it are parsed
+	Path string `yaml:"path"`
+	// Reload parses the templates again once they change and shows the template errors in the browser,
+	// it's meant for development together with assets.dir
+	Reload bool `yaml:"reload"`
+}
+
+type AssetsConfig struct {
+	// Dir serves the templates and the static files from the directory instead of the ones embedded
+	// into the binary, so they can be edited without rebuilding
+	Dir string `yaml:"dir"`
+}
+
+// TLSConfig enables HTTPS when both of the certificate and the key files are set
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// RedirectAddr is the address of the plain HTTP listener redirecting to HTTPS, empty disables it
+	RedirectAddr string `yaml:"redirect_addr"`
+	// ReloadInterval is how often the certificate files are checked for changes, SIGHUP reloads them at once
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+	// HSTSMaxAge is sent in the Strict-Transport-Security header, zero disables the header
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains"`
+}
+
+// Enabled reports whether the server is served over HTTPS
+func (cfg TLSConfig) Enabled() bool {
+	return len(cfg.CertFile) > 0 && len(cfg.KeyFile) > 0
+}
+
+// SecurityConfig holds the values of the security headers, an empty value omits the header
+type SecurityConfig struct {
+	// ContentSecurityPolicy may contain {nonce} which is replaced with the per-request nonce
+	ContentSecurityPolicy string `yaml:"content_security_policy"`
+	// FrameAncestors is appended to the policy, EmbedFrameAncestors is used for the embeddable pages
+	FrameAncestors      string `yaml:"frame_ancestors"`
+	EmbedFrameAncestors string `yaml:"embed_frame_ancestors"`
+	FrameOptions        string `yaml:"frame_options"`
+	ContentTypeOptions  string `yaml:"content_type_options"`
+	ReferrerPolicy      string `yaml:"referrer_policy"`
+	PermissionsPolicy   string `yaml:"permissions_policy"`
+	// SelfHostVue loads Vue from /frontend/js/vue.min.js instead of the CDN
+	SelfHostVue bool `yaml:"self_host_vue"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// TrustedProxies are the IPs or CIDRs whose X-Forwarded-For header is trusted
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Routes maps the route patterns, e.g. /save_post, to their budgets
+	Routes map[string]RateLimitBudget `yaml:"routes"`
+}
+
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSize is the size in bytes from which the responses and the static files are compressed
+	MinSize int `yaml:"min_size"`
+}
+
+type I18nConfig struct {
+	// DefaultLocale is used when neither the user nor the Accept-Language header picks a locale
+	// with a catalog, its catalog also fills in the messages missing in the other ones
+	DefaultLocale string `yaml:"default_locale"`
+}
+
+// RateLimitBudget allows Requests per the duration with bursts of up to Burst requests,
+// zero Requests disables the limit of the route
+type RateLimitBudget struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst"`
+}
+
+// TrustedNetworks parses the trusted proxies, single IPs are converted into the networks of one address
+func (cfg RateLimitConfig) TrustedNetworks() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, proxy := range cfg.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("[%s] isn't an IP address or a CIDR", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("[%s] isn't an IP address or a CIDR", proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+type SessionConfig struct {
+	CookieName string        `yaml:"cookie_name"`
+	Lifetime   time.Duration `yaml:"lifetime"`
+}
+
+type GithubConfig struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+type LogConfig struct {
+	Level         string `yaml:"level"`
+	Format        string `yaml:"format"`
+	PackageLevels string `yaml:"package_levels"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     120 * time.Second,
+			MaxHeaderBytes:  1 << 20,
+			ShutdownDrain:   5 * time.Second,
+			ShutdownTimeout: 20 * time.Second,
+		},
+		Database:  DatabaseConfig{Path: "data/tws.db", Backup: BackupConfig{Interval: 24 * time.Hour, Keep: 7}},
+		Templates: TemplatesConfig{Path: "tmpl"},
+		Session:   SessionConfig{CookieName: "twssessionid", Lifetime: time.Hour},
+		TLS:       TLSConfig{ReloadInterval: time.Minute, HSTSMaxAge: 180 * 24 * time.Hour},
+		RateLimit: RateLimitConfig{
+			Enabled:        true,
+			TrustedProxies: []string{"127.0.0.1", "::1"},
+			Routes: map[string]RateLimitBudget{
+				"/save_post": {Requests: 10, Per: time.Minute, Burst: 5},
+				"/like_post": {Requests: 60, Per: time.Minute, Burst: 20},
+				"/github":    {Requests: 10, Per: time.Minute, Burst: 5},
+				"/login":     {Requests: 20, Per: time.Minute, Burst: 10},
+			},
+		},
+		Compression: CompressionConfig{Enabled: true, MinSize: 1024},
+		I18n:        I18nConfig{DefaultLocale: "en"},
+		Security: SecurityConfig{
+			ContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-{nonce}' https://cdn.jsdelivr.net; " +
+				"style-src 'self'; img-src 'self' https://avatars.githubusercontent.com; object-src 'none'; base-uri 'self'; " +
+				"form-action 'self'",
+			FrameAncestors:      "'none'",
+			EmbedFrameAncestors: "*",
+			FrameOptions:        "DENY",
+			ContentTypeOptions:  "nosniff",
+			ReferrerPolicy:      "strict-origin-when-cross-origin",
+			PermissionsPolicy:   "camera=(), microphone=(), geolocation=(), payment=()",
+		},
+		Log: LogConfig{Level: "info", Format: "logfmt"},
+	}
+}
+
+// setting describes a single value which can be overridden by the environment and the flags
+type setting struct {
+	key   string
+	flag  string
+	usage string
+	value interface{}
+}
+
+// envName returns the environment variable of the setting, e.g. TWS_SERVER_ADDR for server.addr
+func (s setting) envName() string {
+	return envPrefix + strings.ToUpper(strings.Replace(s.key, ".", "_", -1))
+}
+
+// settings lists the overridable values, the secrets have no flags to keep them out of the process list
+func (cfg *Config) settings() []setting {
+	return []setting{
+		{"server.addr", "addr", "Address the server listens on", &cfg.Server.Addr},
+		{"server.read_timeout", "readTimeout", "Maximum duration for reading the whole request", &cfg.Server.ReadTimeout},
+		{"server.write_timeout", "writeTimeout", "Maximum duration before timing out writes of the response", &cfg.Server.WriteTimeout},
+		{"server.idle_timeout", "idleTimeout", "Maximum time to wait for the next request on keep-alive connections", &cfg.Server.IdleTimeout},
+		{"server.max_header_bytes", "maxHeaderBytes", "Maximum size of the request headers in bytes", &cfg.Server.MaxHeaderBytes},
+		{"server.shutdown_drain", "shutdownDrain", "Time /readyz fails on shutdown before the listeners close", &cfg.Server.ShutdownDrain},
+		{"server.shutdown_timeout", "shutdownTimeout", "Time given to in-flight requests to finish on shutdown", &cfg.Server.ShutdownTimeout},
+		{"database.path", "dbPath", "Path to the BoltDB file", &cfg.Database.Path},
+		{"database.backup.dir", "backupDir", "Directory of the scheduled database backups, empty disables them", &cfg.Database.Backup.Dir},
+		{"database.backup.interval", "backupInterval", "Time between the scheduled database backups", &cfg.Database.Backup.Interval},
+		{"database.backup.keep", "backupKeep", "Number of the newest backups kept, 0 keeps all", &cfg.Database.Backup.Keep},
+		{"templates.path", "templatesPath", "Directory of the HTML templates inside of the assets", &cfg.Templates.Path},
+		{"templates.reload", "templatesReload", "Reload the changed templates and show their errors in the browser (development)", &cfg.Templates.Reload},
+		{"assets.dir", "assetsDir", "Serve templates and static files from the directory instead of the embedded ones", &cfg.Assets.Dir},
+		{"session.cookie_name", "sessionCookieName", "Name of the session cookie", &cfg.Session.CookieName},
+		{"session.lifetime", "sessionLifetime", "Lifetime of the user sessions", &cfg.Session.Lifetime},
+		{"tls.cert_file", "tlsCert", "Path to the PEM certificate, enables HTTPS together with -tlsKey", &cfg.TLS.CertFile},
+		{"tls.key_file", "tlsKey", "Path to the PEM private key", &cfg.TLS.KeyFile},
+		{"tls.redirect_addr", "tlsRedirectAddr", "Address of the HTTP listener redirecting to HTTPS, e.g. :80", &cfg.TLS.RedirectAddr},
+		{"tls.reload_interval", "tlsReloadInterval", "How often the certificate files are checked for changes", &cfg.TLS.ReloadInterval},
+		{"tls.hsts_max_age", "hstsMaxAge", "Max age of the Strict-Transport-Security header, 0 disables it", &cfg.TLS.HSTSMaxAge},
+		{"tls.hsts_include_subdomains", "", "", &cfg.TLS.HSTSIncludeSubdomains},
+		{"security.content_security_policy", "", "", &cfg.Security.ContentSecurityPolicy},
+		{"security.frame_ancestors", "", "", &cfg.Security.FrameAncestors},
+		{"security.embed_frame_ancestors", "", "", &cfg.Security.EmbedFrameAncestors},
+		{"security.frame_options", "", "", &cfg.Security.FrameOptions},
+		{"security.content_type_options", "", "", &cfg.Security.ContentTypeOptions},
+		{"security.referrer_policy", "", "", &cfg.Security.ReferrerPolicy},
+		{"security.permissions_policy", "", "", &cfg.Security.PermissionsPolicy},
+		{"security.self_host_vue", "selfHostVue", "Load Vue from /frontend/js/vue.min.js instead of the CDN", &cfg.Security.SelfHostVue},
+		{"rate_limit.enabled", "rateLimit", "Throttle the requests exceeding the route budgets", &cfg.RateLimit.Enabled},
+		{"rate_limit.trusted_proxies", "trustedProxies", "Comma separated IPs or CIDRs of the proxies setting X-Forwarded-For", &cfg.RateLimit.TrustedProxies},
+		{"compression.enabled", "compress", "Compress the responses with gzip or brotli", &cfg.Compression.Enabled},
+		{"compression.min_size", "compressMinSize", "Minimal size in bytes of the compressed responses", &cfg.Compression.MinSize},
+		{"i18n.default_locale", "defaultLocale", "Locale of the UI when the browser asks for none of the translated ones", &cfg.I18n.DefaultLocale},
+		{"github.client_id", "", "", &cfg.Github.ClientID},
+		{"github.client_secret", "", "", &cfg.Github.ClientSecret},
+		{"log.level", "logLevel", "Minimal level of the logs: debug, info, warn or error", &cfg.Log.Level},
+		{"log.format", "logFormat", "Format of the logs: logfmt or json", &cfg.Log.Format},
+		{"log.package_levels", "packageLogLevels", "Log levels of separate packages, e.g. server=debug,session=warn", &cfg.Log.PackageLevels},
+		{"debug", "debug", "If true, error pages will show error details", &cfg.Debug},
+	}
+}
+
+func setValue(value interface{}, raw string) error {
+	switch v := value.(type) {
+	case *string:
+		*v = raw
+	case *bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("[%s] isn't a boolean", raw)
+		}
+		*v = parsed
+	case *int:
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("[%s] isn't an integer", raw)
+		}
+		*v = parsed
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); len(item) > 0 {
+				*v = append(*v, item)
+			}
+		}
+	case *time.Duration:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("[%s] isn't a duration, e.g. 30s or 5m", raw)
+		}
+		*v = parsed
+	default:
+		return fmt.Errorf("unsupported setting type %T", value)
+	}
+	return nil
+}
+
+// flagValue remembers the flag, so it can be applied after the file and the environment
+type flagValue struct {
+	raw    string
+	isSet  bool
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.raw
+}
+
+func (f *flagValue) Set(raw string) error {
+	f.raw = raw
+	f.isSet = true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// Load parses the arguments with the flag set, the caller may register its own flags on it
+// beforehand, and builds the validated configuration
+func Load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+	envPath, _ := lookupEnv(envPrefix + "CONFIG")
+	path := fs.String("config", "", "Path to the YAML config file (default "+DefaultPath+")")
+	flagValues := map[string]*flagValue{}
+	for _, s := range cfg.settings() {
+		if len(s.flag) == 0 {
+			continue
+		}
+		_, isBool := s.value.(*bool)
+		value := &flagValue{isBool: isBool}
+		flagValues[s.key] = value
+		fs.Var(value, s.flag, s.usage+" ("+s.envName()+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	configPath := *path
+	if len(configPath) == 0 {
+		configPath = envPath
+	}
+	if err := cfg.loadFile(configPath); err != nil {
+		return nil, err
+	}
+
+	for _, s := range cfg.settings() {
+		raw, ok := lookupEnv(s.envName())
+		if !ok {
+			continue
+		}
+		if err := setValue(s.value, raw); err != nil {
+			return nil, fmt.Errorf("environment variable %v: %w", s.envName(), err)
+		}
+	}
+	for _, s := range cfg.settings() {
+		value, ok := flagValues[s.key]
+		if !ok || !value.isSet {
+			continue
+		}
+		if err := setValue(s.value, value.raw); err != nil {
+			return nil, fmt.Errorf("flag -%v: %w", s.flag, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads the YAML file, the default file is optional while the explicitly set one isn't
+func (cfg *Config) loadFile(path string) error {
+	explicit := len(path) > 0
+	if !explicit {
+		path = DefaultPath
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(cfg)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("couldn't parse config file %v: %w", path, err)
+	}
+
+	if len(cfg.Github.ClientID) == 0 {
+		cfg.Github.ClientID = cfg.LegacyGithubCID
+	}
+	if len(cfg.Github.ClientSecret) == 0 {
+		cfg.Github.ClientSecret = cfg.LegacyGithubCSec
+	}
+	return nil
+}
+
+// Validate returns the error listing all of the invalid settings
+func (cfg *Config) Validate() error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		addProblem("server.addr [%s] must be in the form of host:port", cfg.Server.Addr)
+	}
+	if cfg.Server.ReadTimeout < 0 || cfg.Server.WriteTimeout < 0 || cfg.Server.IdleTimeout < 0 {
+		addProblem("server timeouts can't be negative")
+	}
+	if cfg.Server.MaxHeaderBytes <= 0 {
+		addProblem("server.max_header_bytes must be positive")
+	}
+	if cfg.Server.ShutdownDrain < 0 {
+		addProblem("server.shutdown_drain can't be negative")
+	}
+	if cfg.Server.ShutdownTimeout <= 0 {
+		addProblem("server.shutdown_timeout must be positive")
+	}
+	if len(cfg.Database.Path) == 0 {
+		addProblem("database.path is required")
+	}
+	if len(cfg.Database.Backup.Dir) > 0 && cfg.Database.Backup.Interval < time.Minute {
+		addProblem("database.backup.interval must be at least a minute")
+	}
+	if cfg.Database.Backup.Keep < 0 {
+		addProblem("database.backup.keep can't be negative")
+	}
+	if len(cfg.Templates.Path) == 0 {
+		addProblem("templates.path is required")
+	}
+	if cfg.Templates.Reload && len(cfg.Assets.Dir) == 0 {
+		addProblem("templates.reload requires assets.dir, the embedded templates never change")
+	}
+	if len(cfg.Assets.Dir) > 0 {
+		if info, err := os.Stat(cfg.Assets.Dir); err != nil || !info.IsDir() {
+			addProblem("assets.dir [%s] must be an existing directory", cfg.Assets.Dir)
+		}
+	}
+	if len(cfg.Session.CookieName) == 0 || strings.ContainsAny(cfg.Session.CookieName, " \t;,=\"") {
+		addProblem("session.cookie_name [%s] must be a non empty token", cfg.Session.CookieName)
+	}
+	if cfg.Session.Lifetime < time.Second {
+		addProblem("session.lifetime must be at least 1s")
+	}
+	if (len(cfg.TLS.CertFile) > 0) != (len(cfg.TLS.KeyFile) > 0) {
+		addProblem("tls.cert_file and tls.key_file must be set together")
+	}
+	if cfg.TLS.Enabled() {
+		for _, file := range []string{cfg.TLS.CertFile, cfg.TLS.KeyFile} {
+			if _, err := os.Stat(file); err != nil {
+				addProblem("tls file [%s] isn't readable: %v", file, err)
+			}
+		}
+		if cfg.TLS.ReloadInterval <= 0 {
+			addProblem("tls.reload_interval must be positive")
+		}
+		if cfg.TLS.HSTSMaxAge < 0 {
+			addProblem("tls.hsts_max_age can't be negative")
+		}
+	}
+	if len(cfg.TLS.RedirectAddr) > 0 {
+		if !cfg.TLS.Enabled() {
+			addProblem("tls.redirect_addr requires tls.cert_file and tls.key_file")
+		}
+		if _, _, err := net.SplitHostPort(cfg.TLS.RedirectAddr); err != nil {
+			addProblem("tls.redirect_addr [%s] must be in the form of host:port", cfg.TLS.RedirectAddr)
+		}
+	}
+	if strings.ContainsAny(cfg.Security.ContentSecurityPolicy+cfg.Security.FrameAncestors+cfg.Security.EmbedFrameAncestors, "\r\n") {
+		addProblem("security headers can't contain line breaks")
+	}
+	if _, err := cfg.RateLimit.TrustedNetworks(); err != nil {
+		addProblem("rate_limit.trusted_proxies: %v", err)
+	}
+	for pattern, budget := range cfg.RateLimit.Routes {
+		if !strings.HasPrefix(pattern, "/") {
+			addProblem("rate_limit.routes [%s] must be a route pattern starting with /", pattern)
+		}
+		if budget.Requests < 0 || budget.Burst < 0 || (budget.Requests > 0 && budget.Per <= 0) {
+			addProblem("rate_limit.routes [%s] needs positive requests, per and burst", pattern)
+		}
+	}
+	if cfg.Compression.MinSize < 0 {
+		addProblem("compression.min_size can't be negative")
+	}
+	if len(cfg.I18n.DefaultLocale) == 0 {
+		addProblem("i18n.default_locale is required")
+	}
+	if _, err := logger.ParseLevel(cfg.Log.Level); err != nil {
+		addProblem("log.level: %v", err)
+	}
+	if _, err := logger.ParseFormat(cfg.Log.Format); err != nil {
+		addProblem("log.format: %v", err)
+	}
+	if _, err := logger.ParsePackageLevels(cfg.Log.PackageLevels); err != nil {
+		addProblem("log.package_levels: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// ValidateServe returns the error listing the missing settings which only the server needs, the admin
+// commands work without them
+func (cfg *Config) ValidateServe() error {
+	var problems []string
+	if len(cfg.Github.ClientID) == 0 {
+		problems = append(problems, fmt.Sprintf("github.client_id is required (%s)", setting{key: "github.client_id"}.envName()))
+	}
+	if len(cfg.Github.ClientSecret) == 0 {
+		problems = append(problems, fmt.Sprintf("github.client_secret is required (%s)", setting{key: "github.client_secret"}.envName()))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
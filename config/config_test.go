@@ -0,0 +1,126 @@
+package config
+
+import (
+	"flag"
+	"github.com/matryer/is"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) (configPath string, templatesPath string) {
+	dir := t.TempDir()
+	templatesPath = filepath.Join(dir, "tmpl")
+	err := os.Mkdir(templatesPath, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath = filepath.Join(dir, "config.yml")
+	content = strings.Replace(content, "TEMPLATES", templatesPath, -1)
+	err = os.WriteFile(configPath, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestLoadLayering(t *testing.T) {
+	is := is.New(t)
+	configPath, templatesPath := writeConfig(t, `
+server:
+  addr: ":9000"
+  read_timeout: 5s
+templates:
+  path: TEMPLATES
+github:
+  client_id: file_id
+  client_secret: file_secret
+log:
+  level: warn
+`)
+
+	env := map[string]string{
+		"TWS_CONFIG":               configPath,
+		"TWS_SERVER_READ_TIMEOUT":  "7s",
+		"TWS_GITHUB_CLIENT_SECRET": "env_secret",
+		"TWS_LOG_LEVEL":            "error",
+	}
+	fs := flag.NewFlagSet("tws", flag.ContinueOnError)
+	cfg, err := Load(fs, []string{"-logLevel", "debug", "-debug"}, lookupIn(env))
+	is.NoErr(err)
+
+	is.Equal(cfg.Server.Addr, ":9000")                // file overrides defaults
+	is.Equal(cfg.Server.WriteTimeout, 30*time.Second) // defaults are kept
+	is.Equal(cfg.Server.ReadTimeout, 7*time.Second)   // environment overrides file
+	is.Equal(cfg.Github.ClientID, "file_id")
+	is.Equal(cfg.Github.ClientSecret, "env_secret")
+	is.Equal(cfg.Log.Level, "debug") // flags override environment
+	is.True(cfg.Debug)
+	is.Equal(cfg.Templates.Path, templatesPath)
+}
+
+func TestLoadLegacyKeys(t *testing.T) {
+	is := is.New(t)
+	configPath, _ := writeConfig(t, "auth_github_cid: 1111\nauth_github_csec: 2222\ntemplates:\n  path: TEMPLATES\n")
+
+	cfg, err := Load(flag.NewFlagSet("tws", flag.ContinueOnError), []string{"-config", configPath}, lookupIn(nil))
+	is.NoErr(err)
+	is.Equal(cfg.Github.ClientID, "1111")
+	is.Equal(cfg.Github.ClientSecret, "2222")
+}
+
+func TestLoadErrors(t *testing.T) {
+	is := is.New(t)
+	configPath, _ := writeConfig(t, "templates:\n  path: TEMPLATES\ngithub:\n  client_id: id\n  client_secret: secret\n")
+	unknownKeyPath, _ := writeConfig(t, "server:\n  adress: \":80\"\n")
+
+	tests := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		expectedErr string
+	}{
+		{"missing explicit file", []string{"-config", "no/such/config.yml"}, nil, "couldn't read config file"},
+		{"unknown key", []string{"-config", unknownKeyPath}, nil, "field adress not found"},
+		{"invalid environment", []string{"-config", configPath}, map[string]string{"TWS_SESSION_LIFETIME": "hour"}, "TWS_SESSION_LIFETIME"},
+		{"invalid flag", []string{"-config", configPath, "-maxHeaderBytes", "big"}, nil, "flag -maxHeaderBytes"},
+		{"invalid address", []string{"-config", configPath, "-addr", "8080"}, nil, "server.addr [8080]"},
+		{"invalid log level", []string{"-config", configPath, "-logLevel", "loud"}, nil, "log.level"},
+		{"reload of embedded templates", []string{"-config", configPath, "-templatesReload"}, nil, "templates.reload requires assets.dir"},
+		{"missing default locale", []string{"-config", configPath, "-defaultLocale", ""}, nil, "i18n.default_locale is required"},
+		{"too frequent backups", []string{"-config", configPath, "-backupDir", "backups", "-backupInterval", "1s"}, nil, "database.backup.interval"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("tws", flag.ContinueOnError)
+			_, err := Load(fs, tt.args, lookupIn(tt.env))
+			is.True(err != nil)
+			is.True(strings.Contains(err.Error(), tt.expectedErr))
+		})
+	}
+}
+
+func TestValidateServe(t *testing.T) {
+	is := is.New(t)
+	configPath, _ := writeConfig(t, "templates:\n  path: TEMPLATES\ngithub:\n  client_id: id\n  client_secret: secret\n")
+
+	// the admin commands don't need the OAuth settings
+	fs := flag.NewFlagSet("tws", flag.ContinueOnError)
+	cfg, err := Load(fs, []string{"-config", configPath}, lookupIn(map[string]string{"TWS_GITHUB_CLIENT_SECRET": ""}))
+	is.NoErr(err)
+	err = cfg.ValidateServe()
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "TWS_GITHUB_CLIENT_SECRET"))
+
+	cfg.Github.ClientSecret = "secret"
+	is.NoErr(cfg.ValidateServe())
+}
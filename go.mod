@@ -3,6 +3,7 @@ module tinywebserver
 go 1.17
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/boltdb/bolt v1.3.1
 	github.com/matryer/is v1.4.0
 	github.com/microcosm-cc/bluemonday v1.0.16
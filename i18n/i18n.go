@@ -0,0 +1,258 @@
+// Package i18n translates the UI. Every locale has a message catalog, a YAML file named after
+// the language tag, e.g. locales/uk.yml. A message is either a string or a map of the plural
+// forms (zero, one, two, few, many, other) chosen by the count passed to T.
+package i18n
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// catalog is the content of the locale file
+type catalog struct {
+	// Name is shown in the language selector, in the language itself
+	Name string `yaml:"name"`
+	// DateFormat is the layout of time.Format for the dates of the posts
+	DateFormat string                 `yaml:"date_format"`
+	Messages   map[string]interface{} `yaml:"messages"`
+}
+
+// Locale translates the messages into one language. The messages missing in the catalog are
+// taken from the default locale, so a partial translation still renders
+type Locale struct {
+	Tag        string
+	Name       string
+	dateFormat string
+	messages   map[string]map[string]string
+	plural     pluralRule
+	fallback   *Locale
+}
+
+// Bundle holds the loaded locales
+type Bundle struct {
+	locales    map[string]*Locale
+	defaultTag string
+}
+
+// Load reads the catalogs from dir, the default locale must be one of them
+func Load(fsys fs.FS, dir string, defaultTag string) (*Bundle, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.yml"))
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{locales: map[string]*Locale{}, defaultTag: normalizeTag(defaultTag)}
+	for _, name := range names {
+		locale, err := loadLocale(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		bundle.locales[locale.Tag] = locale
+	}
+	fallback, ok := bundle.locales[bundle.defaultTag]
+	if !ok {
+		return nil, fmt.Errorf("no catalog for the default locale %q in %v", defaultTag, dir)
+	}
+	for _, locale := range bundle.locales {
+		if locale != fallback {
+			locale.fallback = fallback
+		}
+	}
+	return bundle, nil
+}
+
+func loadLocale(fsys fs.FS, name string) (*Locale, error) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	var cat catalog
+	if err := yaml.Unmarshal(content, &cat); err != nil {
+		return nil, fmt.Errorf("couldn't parse %v: %w", name, err)
+	}
+	tag := normalizeTag(strings.TrimSuffix(path.Base(name), path.Ext(name)))
+	locale := &Locale{
+		Tag:        tag,
+		Name:       cat.Name,
+		dateFormat: cat.DateFormat,
+		messages:   map[string]map[string]string{},
+		plural:     pluralRuleFor(tag),
+	}
+	if len(locale.Name) == 0 {
+		locale.Name = tag
+	}
+	if len(locale.dateFormat) == 0 {
+		locale.dateFormat = time.RFC822
+	}
+	for key, value := range cat.Messages {
+		forms, err := parseMessage(value)
+		if err != nil {
+			return nil, fmt.Errorf("%v: message %q: %w", name, key, err)
+		}
+		locale.messages[key] = forms
+	}
+	return locale, nil
+}
+
+func parseMessage(value interface{}) (map[string]string, error) {
+	switch value := value.(type) {
+	case string:
+		return map[string]string{"other": value}, nil
+	case map[string]interface{}:
+		forms := map[string]string{}
+		for form, text := range value {
+			if !validForms[form] {
+				return nil, fmt.Errorf("unknown plural form %q", form)
+			}
+			text, ok := text.(string)
+			if !ok {
+				return nil, fmt.Errorf("plural form %q isn't a string", form)
+			}
+			forms[form] = text
+		}
+		if len(forms) == 0 {
+			return nil, fmt.Errorf("no plural forms")
+		}
+		return forms, nil
+	}
+	return nil, fmt.Errorf("expected a string or a map of plural forms, got %T", value)
+}
+
+// Locale returns the locale of the tag or the default one
+func (bundle *Bundle) Locale(tag string) *Locale {
+	if locale, ok := bundle.locales[normalizeTag(tag)]; ok {
+		return locale
+	}
+	return bundle.locales[bundle.defaultTag]
+}
+
+// Supported reports whether there is a catalog for the tag
+func (bundle *Bundle) Supported(tag string) bool {
+	_, ok := bundle.locales[normalizeTag(tag)]
+	return ok
+}
+
+// Locales returns the loaded locales sorted by the tag
+func (bundle *Bundle) Locales() []*Locale {
+	locales := make([]*Locale, 0, len(bundle.locales))
+	for _, locale := range bundle.locales {
+		locales = append(locales, locale)
+	}
+	sort.Slice(locales, func(i, j int) bool { return locales[i].Tag < locales[j].Tag })
+	return locales
+}
+
+// Match picks the locale for the Accept-Language header, e.g. "uk-UA,uk;q=0.9,en;q=0.8".
+// A regional tag falls back to its language, uk-UA is served by uk
+func (bundle *Bundle) Match(acceptLanguage string) *Locale {
+	type preference struct {
+		tag string
+		q   float64
+	}
+	var preferences []preference
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		params := strings.Split(part, ";")
+		tag := normalizeTag(params[0])
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		if q > 0 {
+			preferences = append(preferences, preference{tag, q})
+		}
+	}
+	sort.SliceStable(preferences, func(i, j int) bool { return preferences[i].q > preferences[j].q })
+	for _, preference := range preferences {
+		if locale, ok := bundle.locales[preference.tag]; ok {
+			return locale
+		}
+		if i := strings.Index(preference.tag, "-"); i > 0 {
+			if locale, ok := bundle.locales[preference.tag[:i]]; ok {
+				return locale
+			}
+		}
+	}
+	return bundle.locales[bundle.defaultTag]
+}
+
+// T returns the translated message. When the first argument is an integer, it chooses the plural
+// form, the other form is used when the catalog lacks the chosen one. The arguments fill the verbs
+// of the message like in fmt.Sprintf, an unknown key is returned as it is, so it stands out on the page
+func (locale *Locale) T(key string, args ...interface{}) string {
+	if locale == nil {
+		return key
+	}
+	forms, ok := locale.messages[key]
+	plural := locale.plural
+	if !ok && locale.fallback != nil {
+		forms, ok = locale.fallback.messages[key]
+		plural = locale.fallback.plural
+	}
+	if !ok {
+		return key
+	}
+	message, ok := forms["other"]
+	if len(args) > 0 {
+		if n, isCount := toInt(args[0]); isCount {
+			if form, found := forms[plural(n)]; found {
+				message, ok = form, true
+			}
+		}
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// FormatTime formats the time with the date format of the locale
+func (locale *Locale) FormatTime(t time.Time) string {
+	if locale == nil {
+		return t.Format(time.RFC822)
+	}
+	return t.Format(locale.dateFormat)
+}
+
+// Keys returns the message keys of the catalog, the translators use it to find the missing ones
+func (locale *Locale) Keys() []string {
+	keys := make([]string, 0, len(locale.messages))
+	for key := range locale.messages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+func toInt(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case int:
+		return value, true
+	case int64:
+		return int(value), true
+	case int32:
+		return int(value), true
+	case uint:
+		return int(value), true
+	}
+	return 0, false
+}
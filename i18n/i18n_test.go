@@ -0,0 +1,148 @@
+package i18n
+
+import (
+	"github.com/matryer/is"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testCatalogs = fstest.MapFS{
+	"locales/en.yml": {Data: []byte(`
+name: English
+date_format: "Jan 2, 2006"
+messages:
+  greeting: Hello, %s!
+  only.english: Only in English
+  likes:
+    one: "%d like"
+    other: "%d likes"
+`)},
+	"locales/uk.yml": {Data: []byte(`
+name: Українська
+date_format: "02.01.2006"
+messages:
+  greeting: Привіт, %s!
+  likes:
+    one: "%d вподобання"
+    few: "%d вподобання"
+    many: "%d вподобань"
+`)},
+}
+
+func TestT(t *testing.T) {
+	is := is.New(t)
+	bundle, err := Load(testCatalogs, "locales", "en")
+	is.NoErr(err)
+	en, uk := bundle.Locale("en"), bundle.Locale("uk")
+
+	is.Equal(en.T("greeting", "Ann"), "Hello, Ann!")
+	is.Equal(uk.T("greeting", "Ann"), "Привіт, Ann!")
+	is.Equal(en.T("likes", 1), "1 like")
+	is.Equal(en.T("likes", 0), "0 likes")
+	is.Equal(uk.T("likes", 1), "1 вподобання")
+	is.Equal(uk.T("likes", 5), "5 вподобань")
+	// there is no other form to fall back to
+	is.Equal(uk.T("likes"), "likes")
+	// the messages missing in the catalog come from the default locale
+	is.Equal(uk.T("only.english"), "Only in English")
+	is.Equal(uk.T("no.such.key"), "no.such.key")
+	is.Equal(bundle.Locale("de"), en)
+
+	date := time.Date(2022, time.March, 8, 10, 0, 0, 0, time.UTC)
+	is.Equal(en.FormatTime(date), "Mar 8, 2022")
+	is.Equal(uk.FormatTime(date), "08.03.2022")
+
+	var missing *Locale
+	is.Equal(missing.T("greeting"), "greeting")
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		catalogs fstest.MapFS
+		expected string
+	}{
+		{"missing default", fstest.MapFS{"locales/uk.yml": {Data: []byte("messages: {}")}}, "default locale"},
+		{"unknown form", fstest.MapFS{"locales/en.yml": {Data: []byte("messages: {a: {several: x, other: y}}")}}, "unknown plural form"},
+		{"no forms", fstest.MapFS{"locales/en.yml": {Data: []byte("messages: {a: {}}")}}, "no plural forms"},
+		{"nested", fstest.MapFS{"locales/en.yml": {Data: []byte("messages: {a: [x]}")}}, "expected a string"},
+	}
+	for _, tt := range tests {
+		_, err := Load(tt.catalogs, "locales", "en")
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%v: expected an error with %q, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	bundle, err := Load(testCatalogs, "locales", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		acceptLanguage string
+		expected       string
+	}{
+		{"", "en"},
+		{"uk", "uk"},
+		{"uk-UA,uk;q=0.9,en-US;q=0.8,en;q=0.7", "uk"},
+		{"de-DE,en;q=0.5,uk;q=0.8", "uk"},
+		{"en;q=0.9, UK_ua", "uk"},
+		{"uk;q=0, de", "en"},
+		{"*", "en"},
+	}
+	for _, tt := range tests {
+		if tag := bundle.Match(tt.acceptLanguage).Tag; tag != tt.expected {
+			t.Errorf("Match(%q) = %v, expected %v", tt.acceptLanguage, tag, tt.expected)
+		}
+	}
+}
+
+func TestPluralRules(t *testing.T) {
+	tests := []struct {
+		tag      string
+		n        int
+		expected string
+	}{
+		{"en", 1, "one"},
+		{"en", 0, "other"},
+		{"en-us", 2, "other"},
+		{"fr", 0, "one"},
+		{"uk", 1, "one"},
+		{"uk", 21, "one"},
+		{"uk", 11, "many"},
+		{"uk", 3, "few"},
+		{"uk", 13, "many"},
+		{"uk", 24, "few"},
+		{"uk", 25, "many"},
+		{"pl", 1, "one"},
+		{"pl", 21, "many"},
+		{"pl", 22, "few"},
+		{"xx", 1, "one"},
+	}
+	for _, tt := range tests {
+		if form := pluralRuleFor(tt.tag)(tt.n); form != tt.expected {
+			t.Errorf("%v: plural form of %v is %v, expected %v", tt.tag, tt.n, form, tt.expected)
+		}
+	}
+}
+
+// TestCatalogsComplete checks that the catalogs shipped with the server translate every message
+func TestCatalogsComplete(t *testing.T) {
+	bundle, err := Load(os.DirFS(".."), "locales", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultKeys := bundle.Locale("en").Keys()
+	for _, locale := range bundle.Locales() {
+		for _, key := range defaultKeys {
+			if _, ok := locale.messages[key]; !ok {
+				t.Errorf("%v: message %q isn't translated", locale.Tag, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package i18n
+
+import "strings"
+
+// pluralRule returns the plural form of the count, the forms are the CLDR plural categories
+type pluralRule func(n int) string
+
+var validForms = map[string]bool{"zero": true, "one": true, "two": true, "few": true, "many": true, "other": true}
+
+// pluralRules are keyed by the language, the languages without a rule use oneOther
+var pluralRules = map[string]pluralRule{
+	"en": oneOther,
+	"de": oneOther,
+	"es": oneOther,
+	"it": oneOther,
+	"fr": frenchRule,
+	"uk": eastSlavicRule,
+	"ru": eastSlavicRule,
+	"be": eastSlavicRule,
+	"pl": polishRule,
+}
+
+func pluralRuleFor(tag string) pluralRule {
+	language := tag
+	if i := strings.Index(tag, "-"); i > 0 {
+		language = tag[:i]
+	}
+	if rule, ok := pluralRules[language]; ok {
+		return rule
+	}
+	return oneOther
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func oneOther(n int) string {
+	if abs(n) == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+func frenchRule(n int) string {
+	if abs(n) < 2 {
+		return "one"
+	}
+	return "other"
+}
+
+// eastSlavicRule: 1, 21, 31 are one, 2-4, 22-24 are few, the rest are many
+func eastSlavicRule(n int) string {
+	n = abs(n)
+	mod10, mod100 := n%10, n%100
+	switch {
+	case mod10 == 1 && mod100 != 11:
+		return "one"
+	case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+		return "few"
+	}
+	return "many"
+}
+
+// polishRule is the east Slavic one except that only 1 itself is one
+func polishRule(n int) string {
+	n = abs(n)
+	if n == 1 {
+		return "one"
+	}
+	mod10, mod100 := n%10, n%100
+	if mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14) {
+		return "few"
+	}
+	return "many"
+}
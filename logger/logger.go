@@ -0,0 +1,324 @@
+// Package logger implements leveled structured logging with logfmt or JSON output.
+// Every logger belongs to a package, the level can be configured globally and per package.
+// Values of keys which look like secrets (tokens, session ids, etc.) are redacted.
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (level Level) String() string {
+	if name, ok := levelNames[level]; ok {
+		return name
+	}
+	return "level(" + strconv.Itoa(int(level)) + ")"
+}
+
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level [%s]", name)
+}
+
+type Format int
+
+const (
+	FormatLogfmt Format = iota
+	FormatJSON
+)
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "logfmt":
+		return FormatLogfmt, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatLogfmt, fmt.Errorf("unknown log format [%s]", name)
+}
+
+const redactedValue = "[REDACTED]"
+
+var redactedKeys = []string{"token", "secret", "password", "sid", "session_id", "cookie", "authorization", "auth_code"}
+
+func isRedactedKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, redactedKey := range redactedKeys {
+		if key == redactedKey || strings.HasSuffix(key, "_"+redactedKey) || strings.HasPrefix(key, redactedKey+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+type config struct {
+	lock          sync.Mutex
+	output        io.Writer
+	format        Format
+	level         Level
+	packageLevels map[string]Level
+}
+
+var cfg = &config{
+	output:        os.Stderr,
+	format:        FormatLogfmt,
+	level:         LevelInfo,
+	packageLevels: map[string]Level{},
+}
+
+func SetOutput(w io.Writer) {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.output = w
+}
+
+func SetFormat(format Format) {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.format = format
+}
+
+// SetLevel sets the level for all packages without their own level
+func SetLevel(level Level) {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.level = level
+}
+
+func SetPackageLevel(pkg string, level Level) {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	cfg.packageLevels[pkg] = level
+}
+
+// ParsePackageLevels parses levels in the form of "server=debug,session=warn"
+func ParsePackageLevels(levels string) (map[string]Level, error) {
+	result := map[string]Level{}
+	for _, pair := range strings.Split(levels, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("package level [%s] must be in the form of package=level", pair)
+		}
+		level, err := ParseLevel(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		result[parts[0]] = level
+	}
+	return result, nil
+}
+
+// SetPackageLevels sets levels in the form of "server=debug,session=warn"
+func SetPackageLevels(levels string) error {
+	parsedLevels, err := ParsePackageLevels(levels)
+	if err != nil {
+		return err
+	}
+	for pkg, level := range parsedLevels {
+		SetPackageLevel(pkg, level)
+	}
+	return nil
+}
+
+func packageLevel(pkg string) Level {
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	if level, ok := cfg.packageLevels[pkg]; ok {
+		return level
+	}
+	return cfg.level
+}
+
+type Logger struct {
+	pkg     string
+	keyvals []interface{}
+}
+
+func New(pkg string) *Logger {
+	return &Logger{pkg: pkg}
+}
+
+// With returns a logger which adds the key value pairs to every record
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	newKeyvals := make([]interface{}, 0, len(l.keyvals)+len(keyvals))
+	newKeyvals = append(newKeyvals, l.keyvals...)
+	newKeyvals = append(newKeyvals, keyvals...)
+	return &Logger{pkg: l.pkg, keyvals: newKeyvals}
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= packageLevel(l.pkg)
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(LevelDebug, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(LevelInfo, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(LevelWarn, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+}
+
+// Fatal logs the record on error level and exits the process
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := make([]interface{}, 0, 8+len(l.keyvals)+len(keyvals))
+	fields = append(fields, "time", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "pkg", l.pkg, "msg", msg)
+	fields = append(fields, l.keyvals...)
+	fields = append(fields, keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(MISSING)")
+	}
+
+	cfg.lock.Lock()
+	defer cfg.lock.Unlock()
+	var buf bytes.Buffer
+	if cfg.format == FormatJSON {
+		writeJSON(&buf, fields)
+	} else {
+		writeLogfmt(&buf, fields)
+	}
+	cfg.output.Write(buf.Bytes())
+}
+
+func formatValue(key string, value interface{}) interface{} {
+	if isRedactedKey(key) {
+		return redactedValue
+	}
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case []byte:
+		return string(v)
+	case string, bool, int, int64, uint64, float64:
+		return v
+	case time.Duration:
+		return v.String()
+	}
+	return fmt.Sprintf("%+v", value)
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		value := fmt.Sprint(formatValue(key, fields[i+1]))
+		if len(value) == 0 || strings.ContainsAny(value, " =\"\t\r\n") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		keyBuf, _ := json.Marshal(key)
+		buf.Write(keyBuf)
+		buf.WriteByte(':')
+		valueBuf, err := json.Marshal(formatValue(key, fields[i+1]))
+		if err != nil {
+			valueBuf, _ = json.Marshal(err.Error())
+		}
+		buf.Write(valueBuf)
+	}
+	buf.WriteString("}\n")
+}
+
+// Writer returns a writer which logs every written line with the desired level, it's used
+// to redirect the output of the standard library loggers
+func (l *Logger) Writer(level Level) io.Writer {
+	return &lineWriter{logger: l, level: level}
+}
+
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.logger.log(w.level, strings.TrimRight(string(p), "\n"), nil)
+	return len(p), nil
+}
+
+type loggerKey struct{}
+
+func WithContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger stored inside the context, loggers of other packages are
+// converted to the fallback package while keeping the attached key value pairs
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if ctx == nil {
+		return fallback
+	}
+	l, ok := ctx.Value(loggerKey{}).(*Logger)
+	if !ok {
+		return fallback
+	}
+	if l.pkg != fallback.pkg {
+		return fallback.With(l.keyvals...)
+	}
+	return l
+}
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/matryer/is"
+	"strings"
+	"testing"
+)
+
+func captureOutput(t *testing.T, format Format) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	SetOutput(buf)
+	SetFormat(format)
+	SetLevel(LevelInfo)
+	t.Cleanup(func() {
+		cfg.lock.Lock()
+		cfg.packageLevels = map[string]Level{}
+		cfg.lock.Unlock()
+		SetLevel(LevelInfo)
+		SetFormat(FormatLogfmt)
+	})
+	return buf
+}
+
+func TestLevels(t *testing.T) {
+	is := is.New(t)
+	buf := captureOutput(t, FormatLogfmt)
+
+	l := New("server")
+	l.Debug("hidden")
+	l.Info("shown")
+	is.True(!strings.Contains(buf.String(), "hidden"))
+	is.True(strings.Contains(buf.String(), "msg=shown"))
+
+	err := SetPackageLevels("server=debug, session=error")
+	is.NoErr(err)
+	buf.Reset()
+	l.Debug("visible")
+	New("session").Warn("hidden")
+	is.True(strings.Contains(buf.String(), "msg=visible"))
+	is.True(!strings.Contains(buf.String(), "hidden"))
+
+	is.True(SetPackageLevels("server") != nil)
+	is.True(SetPackageLevels("server=loud") != nil)
+}
+
+func TestLogfmt(t *testing.T) {
+	is := is.New(t)
+	buf := captureOutput(t, FormatLogfmt)
+
+	New("server").With("request_id", "abc").Info("request served", "path", "/view/index", "err", fmt.Errorf("page doesn't exist"), "odd")
+	line := buf.String()
+	is.True(strings.Contains(line, " level=info pkg=server msg=\"request served\" request_id=abc path=/view/index"))
+	is.True(strings.Contains(line, "err=\"page doesn't exist\""))
+	is.True(strings.HasSuffix(line, "odd=(MISSING)\n"))
+}
+
+func TestJSONAndRedaction(t *testing.T) {
+	is := is.New(t)
+	buf := captureOutput(t, FormatJSON)
+
+	New("server").Info("user logged in", "user_id", "42", "access_token", "gho_secret", "sid", "abcdef", "client_secret", "1111", "status", 200)
+	record := map[string]interface{}{}
+	err := json.Unmarshal(buf.Bytes(), &record)
+	is.NoErr(err)
+	is.Equal(record["msg"], "user logged in")
+	is.Equal(record["level"], "info")
+	is.Equal(record["user_id"], "42")
+	is.Equal(record["access_token"], redactedValue)
+	is.Equal(record["sid"], redactedValue)
+	is.Equal(record["client_secret"], redactedValue)
+	is.Equal(record["status"], float64(200))
+	is.True(!strings.Contains(buf.String(), "gho_secret"))
+}
+
+func TestFromContext(t *testing.T) {
+	is := is.New(t)
+	buf := captureOutput(t, FormatLogfmt)
+
+	fallback := New("db")
+	is.Equal(FromContext(context.Background(), fallback), fallback)
+
+	ctx := WithContext(context.Background(), New("server").With("request_id", "xyz"))
+	FromContext(ctx, fallback).Info("loaded user")
+	is.True(strings.Contains(buf.String(), "pkg=db msg=\"loaded user\" request_id=xyz"))
+}
@@ -2,22 +2,47 @@ package main
 
 import (
 	"flag"
-	"io/ioutil"
 	"log"
+	"os"
+	"tinywebserver/config"
+	"tinywebserver/logger"
 	"tinywebserver/server"
 )
 
-func init() {
-	//TODO: Probably add more sophisticated logging system in the future
-	//Also the performance of this type of logging should be measured
-	enableLogs := flag.Bool("logging", false, "If true, will enable logs")
-	flag.Parse()
-	if !(*enableLogs) {
-		log.SetOutput(ioutil.Discard)
-		log.SetFlags(0)
-	}
+var mainLog = logger.New("main")
+
+func configureLogger(cfg config.LogConfig) {
+	//The values are already checked by the config validation
+	level, _ := logger.ParseLevel(cfg.Level)
+	logger.SetLevel(level)
+	format, _ := logger.ParseFormat(cfg.Format)
+	logger.SetFormat(format)
+	logger.SetPackageLevels(cfg.PackageLevels)
+
+	//Messages of the standard library (e.g. net/http) go through our logger as well
+	log.SetFlags(0)
+	log.SetOutput(logger.New("stdlib").Writer(logger.LevelWarn))
 }
 
 func main() {
-	server.Start()
+	adminCommands := server.AdminCommands{}
+	adminCommands.RegisterFlags(flag.CommandLine)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		mainLog.Fatal("couldn't load configuration", "err", err)
+	}
+	configureLogger(cfg.Log)
+
+	exit, err := server.RunAdminCommands(cfg, adminCommands)
+	if err != nil {
+		mainLog.Fatal("admin command failed", "err", err)
+	}
+	if exit {
+		return
+	}
+
+	err = server.Start(cfg, assets)
+	if err != nil {
+		mainLog.Fatal("server failed", "err", err)
+	}
 }
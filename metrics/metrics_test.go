@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	is := is.New(t)
+	reg := NewRegistry()
+
+	requests := reg.NewCounter("requests_total", "Number of requests.", "route", "status")
+	requests.Inc("/profile/{id}", "200")
+	requests.Inc("/profile/{id}", "200")
+	requests.Add(3, "/view/{title}", "404")
+	is.Equal(requests.Value("/profile/{id}", "200"), float64(2))
+
+	var buf bytes.Buffer
+	is.NoErr(reg.Write(&buf))
+	is.Equal(buf.String(), `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{route="/profile/{id}",status="200"} 2
+requests_total{route="/view/{title}",status="404"} 3
+`)
+}
+
+func TestHistogramAndGauge(t *testing.T) {
+	is := is.New(t)
+	reg := NewRegistry()
+
+	duration := reg.NewHistogram("duration_seconds", "Duration.", []float64{1, 0.1}, "method")
+	duration.Observe(0.05, "getUser")
+	duration.Observe(0.5, "getUser")
+	duration.Observe(2, "getUser")
+	is.Equal(duration.Count("getUser"), uint64(3))
+	reg.NewGaugeFunc("active_sessions", "Active \"sessions\".\nSecond line.", func() float64 { return 7 })
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	is.True(strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+	is.Equal(rec.Body.String(), `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{method="getUser",le="0.1"} 1
+duration_seconds_bucket{method="getUser",le="1"} 2
+duration_seconds_bucket{method="getUser",le="+Inf"} 3
+duration_seconds_sum{method="getUser"} 2.55
+duration_seconds_count{method="getUser"} 3
+# HELP active_sessions Active "sessions".\nSecond line.
+# TYPE active_sessions gauge
+active_sessions 7
+`)
+}
+
+func isPanic(is *is.I, f func()) {
+	defer func() {
+		is.True(recover() != nil)
+	}()
+
+	f()
+}
+
+func TestMisuse(t *testing.T) {
+	is := is.New(t)
+	reg := NewRegistry()
+
+	counter := reg.NewCounter("posts_total", "Posts.", "type")
+	isPanic(is, func() { reg.NewCounter("posts_total", "Posts.") })
+	isPanic(is, func() { counter.Inc() })
+	isPanic(is, func() { counter.Add(-1, "post") })
+}
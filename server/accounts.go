@@ -0,0 +1,277 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"net/http"
+	"net/url"
+	"tinywebserver/session"
+	"tinywebserver/utils"
+)
+
+// deletionPolicy tells what happens with the posts of a deleted account
+type deletionPolicy string
+
+const (
+	// deletionDelete removes the posts along with the account
+	deletionDelete deletionPolicy = "delete"
+	// deletionAnonymize keeps the posts without their creator, they are shown as posted by a deleted account
+	deletionAnonymize deletionPolicy = "anonymize"
+)
+
+func parseDeletionPolicy(value string) (deletionPolicy, error) {
+	switch policy := deletionPolicy(value); policy {
+	case deletionDelete, deletionAnonymize:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown deletion policy %q, expected %v or %v", value, deletionDelete, deletionAnonymize)
+}
+
+type deletionStats struct {
+	Deleted    int
+	Anonymized int
+	// Detached are the reposts and the quotes of the other users which pointed at the removed posts
+	Detached int
+	Unliked  int
+}
+
+func (stats deletionStats) String() string {
+	return fmt.Sprintf("%v deleted and %v anonymized posts, %v detached reposts and quotes, %v removed likes",
+		stats.Deleted, stats.Anonymized, stats.Detached, stats.Unliked)
+}
+
+// deleteUser removes the account in a single transaction: its posts are deleted or anonymized by the
+// policy and its likes are removed from all of the posts
+func (db *twsDB) deleteUser(userID string, policy deletionPolicy) (stats deletionStats, err error) {
+	err = db.update("deleteUser", func(tx *bolt.Tx) error {
+		usersBucket, postsBucket := getBucket(tx, cUsersBucket), getBucket(tx, cPostsBucket)
+		if usersBucket == nil || postsBucket == nil {
+			return fmt.Errorf("the database isn't initialized")
+		}
+		buf := usersBucket.Get([]byte(userID))
+		if buf == nil {
+			return errUserNotExist
+		}
+		user := dbUserData{}
+		if err := json.Unmarshal(buf, &user); err != nil {
+			return err
+		}
+
+		removed := map[int]bool{}
+		for _, postID := range userPostIDs(tx, []byte(userID)) {
+			key := utils.Itob(postID)
+			buf := postsBucket.Get(key)
+			if buf == nil {
+				continue
+			}
+			post := dbPost{}
+			if err := json.Unmarshal(buf, &post); err != nil {
+				return err
+			}
+			if policy == deletionDelete {
+				if err := unindexPost(tx, postID, post); err != nil {
+					return err
+				}
+				if err := deletePostLikes(tx, postID); err != nil {
+					return err
+				}
+				if err := postsBucket.Delete(key); err != nil {
+					return err
+				}
+				removed[postID] = true
+				stats.Deleted++
+				continue
+			}
+			post.CreatorId = nil
+			if buf, err = json.Marshal(post); err != nil {
+				return err
+			}
+			if err := postsBucket.Put(key, buf); err != nil {
+				return err
+			}
+			stats.Anonymized++
+		}
+		if err := usersBucket.Delete([]byte(userID)); err != nil {
+			return err
+		}
+		if userPostsBucket(tx, []byte(userID)) != nil {
+			if err := getBucket(tx, cUserPostsBucket).DeleteBucket([]byte(userID)); err != nil {
+				return err
+			}
+		}
+		return detachRemovedPosts(tx, removed, userID, &stats)
+	})
+	if err != nil {
+		return deletionStats{}, err
+	}
+	db.logger().Info("user deleted", "user_id", userID, "policy", policy, "stats", stats.String())
+	return stats, nil
+}
+
+// detachRemovedPosts cleans up the posts pointing at the removed ones. The reposts have nothing left
+// to show, so they are removed too, and the quotes keep their text as the regular posts. The likes of
+// unliking, when it's set, are removed from all of the posts on the way
+func detachRemovedPosts(tx *bolt.Tx, removed map[int]bool, unliking string, stats *deletionStats) error {
+	postsBucket := getBucket(tx, cPostsBucket)
+	if postsBucket == nil {
+		return fmt.Errorf(cPostsBucketNotExistError)
+	}
+	if len(unliking) > 0 {
+		if err := unlikeAll(tx, unliking, stats); err != nil {
+			return err
+		}
+	}
+	for len(removed) > 0 {
+		// the bucket can't be changed while it's iterated, the changes are collected first
+		changed := map[int]dbPost{}
+		removedNow := map[int]bool{}
+		err := postsBucket.ForEach(func(k, v []byte) error {
+			post := dbPost{}
+			if err := json.Unmarshal(v, &post); err != nil {
+				return err
+			}
+			id := utils.Btoi(k)
+			if removed[post.RepostId] {
+				if len(post.Text) == 0 {
+					removedNow[id] = true
+				} else {
+					post.RepostId = 0
+					changed[id] = post
+				}
+				stats.Detached++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for id, post := range changed {
+			if removedNow[id] {
+				continue
+			}
+			buf, err := json.Marshal(post)
+			if err != nil {
+				return err
+			}
+			if err := postsBucket.Put(utils.Itob(id), buf); err != nil {
+				return err
+			}
+		}
+		for id := range removedNow {
+			if err := removeRepost(tx, id); err != nil {
+				return err
+			}
+		}
+		// the reposts of the removed reposts are detached on the next round
+		removed = removedNow
+	}
+	return nil
+}
+
+func removeRepost(tx *bolt.Tx, postID int) error {
+	postsBucket, usersBucket := getBucket(tx, cPostsBucket), getBucket(tx, cUsersBucket)
+	key := utils.Itob(postID)
+	post := dbPost{}
+	if err := json.Unmarshal(postsBucket.Get(key), &post); err != nil {
+		return err
+	}
+	if err := unindexPost(tx, postID, post); err != nil {
+		return err
+	}
+	if err := deletePostLikes(tx, postID); err != nil {
+		return err
+	}
+	if err := postsBucket.Delete(key); err != nil {
+		return err
+	}
+	// the anonymized reposts have no creator to update
+	if len(post.CreatorId) == 0 || usersBucket.Get(post.CreatorId) == nil {
+		return nil
+	}
+	return removePostFromUser(tx, post.CreatorId, postID)
+}
+
+func (env *environment) deleteAccount(r *http.Request, userID string, policy deletionPolicy) (deletionStats, error) {
+	stats, err := env.requestDB(r).deleteUser(userID, policy)
+	if err != nil {
+		return stats, err
+	}
+	if env.takeouts != nil {
+		env.takeouts.drop(userID)
+	}
+	accountsDeleted.Inc(string(policy))
+	return stats, nil
+}
+
+func (env *environment) accountSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	env.render(w, r, "settings_account.html", nil)
+}
+
+// deleteAccountHandler deletes the account of the logged in user, who confirms it by typing their user ID
+func (env *environment) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	userData, err := env.readUserData(r)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	policy, err := parseDeletionPolicy(r.PostFormValue("policy"))
+	if err != nil {
+		env.renderError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if r.PostFormValue("confirm") != userData.Id {
+		env.addFlash(r, session.FlashError, "flash.account_confirm")
+		http.Redirect(w, r, "/settings/account/", http.StatusFound)
+		return
+	}
+	if _, err := env.deleteAccount(r, userData.Id, policy); err != nil {
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	env.sessionManager.DestroySession(w, r)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// adminDeleteUserHandler lets the admins delete the accounts of the other users from their profiles
+func (env *environment) adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := pathParam(r, "id")
+	policy, err := parseDeletionPolicy(r.PostFormValue("policy"))
+	if err != nil {
+		env.renderError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if len(r.PostFormValue("confirm")) == 0 {
+		env.addFlash(r, session.FlashError, "flash.account_not_confirmed")
+		http.Redirect(w, r, "/profile/"+url.PathEscape(userID), http.StatusFound)
+		return
+	}
+	stats, err := env.deleteAccount(r, userID, policy)
+	if errors.Is(err, errUserNotExist) {
+		env.renderError(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	requestLog(r).Info("admin deleted user", "user_id", userID, "policy", policy, "stats", stats.String())
+	env.addFlash(r, session.FlashSuccess, "flash.account_deleted", userID)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// deleteUserCommand deletes the account from the command line
+func deleteUserCommand(db *bolt.DB, userID string, policy string) error {
+	parsed, err := parseDeletionPolicy(policy)
+	if err != nil {
+		return err
+	}
+	stats, err := (&twsDB{db: db}).deleteUser(userID, parsed)
+	if err != nil {
+		return fmt.Errorf("couldn't delete user %v: %w", userID, err)
+	}
+	fmt.Printf("Deleted user %v: %v\n", userID, stats)
+	return nil
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"tinywebserver/session"
+	"tinywebserver/utils"
+)
+
+// accountsTestDB has alice's post reposted by bob, quoted by carol and reposted again from bob's
+// repost by carol, and alice's like and repost of bob's post
+func accountsTestDB(is *is.I, t *testing.T) (db *twsDB, posts map[string]int) {
+	db = migratedTestDB(is, t)
+	for _, id := range []string{"alice", "bob", "carol"} {
+		createTestUser(is, db, id, id+".png")
+	}
+	posts = map[string]int{}
+	var err error
+	posts["alice"], err = db.saveUserPost([]byte("alice"), "alice's post")
+	is.NoErr(err)
+	posts["bob"], err = db.saveUserPost([]byte("bob"), "bob's post")
+	is.NoErr(err)
+	posts["bob repost"], err = db.repostUserPost(utils.Itob(posts["alice"]), []byte("bob"), "")
+	is.NoErr(err)
+	posts["carol quote"], err = db.repostUserPost(utils.Itob(posts["alice"]), []byte("carol"), "carol's quote")
+	is.NoErr(err)
+	posts["carol repost"], err = db.repostUserPost(utils.Itob(posts["bob repost"]), []byte("carol"), "")
+	is.NoErr(err)
+	posts["alice repost"], err = db.repostUserPost(utils.Itob(posts["bob"]), []byte("alice"), "")
+	is.NoErr(err)
+	is.NoErr(db.toggleLikeOnUserPost([]byte("bob"), posts["bob"], "alice"))
+	is.NoErr(db.toggleLikeOnUserPost([]byte("bob"), posts["bob"], "carol"))
+	return db, posts
+}
+
+func TestDeleteUser(t *testing.T) {
+	is := is.New(t)
+
+	db, posts := accountsTestDB(is, t)
+	stats, err := db.deleteUser("alice", deletionDelete)
+	is.NoErr(err)
+	is.Equal(stats, deletionStats{Deleted: 2, Detached: 3, Unliked: 1})
+	_, err = db.getUser("alice")
+	is.True(errors.Is(err, errUserNotExist))
+	for _, name := range []string{"alice", "alice repost", "bob repost", "carol repost"} {
+		_, err = db.getUserPost(posts[name])
+		is.True(errors.Is(err, errPostNotExist))
+	}
+	quote, err := db.getUserPost(posts["carol quote"])
+	is.NoErr(err)
+	is.Equal(quote.Text, "carol's quote")
+	is.Equal(quote.RepostId, 0)
+	bobsPost, err := db.getUserPost(posts["bob"])
+	is.NoErr(err)
+	is.Equal(bobsPost.LikesCount, 1)
+	likers, _, err := db.getPostLikes(posts["bob"], "", 10)
+	is.NoErr(err)
+	is.Equal(likers, []string{"carol"})
+	bobPostIDs, err := db.getUserPostIDs("bob")
+	is.NoErr(err)
+	is.Equal(bobPostIDs, []int{posts["bob"]})
+	carolPostIDs, err := db.getUserPostIDs("carol")
+	is.NoErr(err)
+	is.Equal(carolPostIDs, []int{posts["carol quote"]})
+
+	db, posts = accountsTestDB(is, t)
+	stats, err = db.deleteUser("alice", deletionAnonymize)
+	is.NoErr(err)
+	is.Equal(stats, deletionStats{Anonymized: 2, Unliked: 1})
+	anonymized, err := db.getUserPost(posts["alice"])
+	is.NoErr(err)
+	is.Equal(anonymized.Text, "alice's post")
+	is.Equal(len(anonymized.CreatorId), 0)
+	// the anonymized posts are shown without the creator
+	post := twsPost{}
+	is.NoErr(post.constructUserPost(db, posts["alice"]))
+	is.Equal(post.ConstructUserProfileUrl(), "#")
+	repost, err := db.getUserPost(posts["bob repost"])
+	is.NoErr(err)
+	is.Equal(repost.RepostId, posts["alice"])
+
+	_, err = db.deleteUser("nobody", deletionDelete)
+	is.True(errors.Is(err, errUserNotExist))
+}
+
+func TestDeleteUserPostKeepsReposts(t *testing.T) {
+	is := is.New(t)
+	db, posts := accountsTestDB(is, t)
+	is.NoErr(db.deleteUserPost([]byte("alice"), posts["alice"]))
+
+	// the other users' reposts and quotes stay and show the post as deleted
+	repost, err := db.getUserPost(posts["bob repost"])
+	is.NoErr(err)
+	is.Equal(repost.RepostId, posts["alice"])
+	quote, err := db.getUserPost(posts["carol quote"])
+	is.NoErr(err)
+	is.Equal(quote.RepostId, posts["alice"])
+	bobPostIDs, err := db.getUserPostIDs("bob")
+	is.NoErr(err)
+	is.Equal(bobPostIDs, []int{posts["bob"], posts["bob repost"]})
+	reposted, err := loadRepostedPost(db, posts["alice"])
+	is.NoErr(err)
+	is.True(reposted.Deleted)
+}
+
+func TestDeleteAccountHandlers(t *testing.T) {
+	is := is.New(t)
+	db := &stubDB{}
+	env := environment{db: db, sessionManager: session.NewManager("memory", "twssessionid", 3600), takeouts: newTakeoutJobs()}
+	mux := env.routes()
+	post := func(path string, form url.Values, userData TwsUserData) *httptest.ResponseRecorder {
+		form.Set("csrf_token", testCSRFToken)
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		loginTestUser(&env, req, userData)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/settings/account/", nil)
+	loginTestUser(&env, req, defaultTestUserData)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusOK)
+	is.True(strings.Contains(rec.Body.String(), `action="/settings/account/delete/"`))
+
+	checkIfRedirect(post("/settings/account/delete/", url.Values{"policy": {"delete"}, "confirm": {"someone else"}}, defaultTestUserData), "/settings/account/", t)
+	is.Equal(db.deletedUser, "")
+	is.Equal(post("/settings/account/delete/", url.Values{"policy": {"burn"}, "confirm": {defaultTestUserData.Id}}, defaultTestUserData).Code, http.StatusBadRequest)
+	rec = post("/settings/account/delete/", url.Values{"policy": {"anonymize"}, "confirm": {defaultTestUserData.Id}}, defaultTestUserData)
+	checkIfRedirect(rec, "/", t)
+	is.Equal(db.deletedUser, defaultTestUserData.Id)
+	is.True(strings.Contains(rec.Header().Get("Set-Cookie"), "twssessionid="))
+
+	db.deletedUser = ""
+	is.Equal(post("/admin/users/victim/delete/", url.Values{"policy": {"delete"}, "confirm": {"on"}}, defaultTestUserData).Code, http.StatusForbidden)
+	adminData := defaultTestUserData
+	adminData.AdminRight = ADMIN
+	checkIfRedirect(post("/admin/users/victim/delete/", url.Values{"policy": {"delete"}}, adminData), "/profile/victim", t)
+	is.Equal(db.deletedUser, "")
+	checkIfRedirect(post("/admin/users/victim/delete/", url.Values{"policy": {"delete"}, "confirm": {"on"}}, adminData), "/", t)
+	is.Equal(db.deletedUser, "victim")
+}
+
+func TestRequireAuthDeletedAccount(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	createTestUser(is, db, defaultTestUserData.Id, "")
+	env := environment{db: db, sessionManager: session.NewManager("memory", "twssessionid", 3600)}
+	mux := env.routes()
+	settings := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/settings/account/", nil)
+		loginTestUser(&env, req, defaultTestUserData)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	is.Equal(settings().Code, http.StatusOK)
+	_, err := db.deleteUser(defaultTestUserData.Id, deletionDelete)
+	is.NoErr(err)
+	checkIfRedirect(settings(), "/", t)
+}
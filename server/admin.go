@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"tinywebserver/config"
+)
+
+// AdminCommands are the maintenance actions requested from the command line
+type AdminCommands struct {
+	ListUsers  bool
+	WipeUsers  bool
+	WipePosts  bool
+	SetAdmin   string
+	PutOnEarth string
+	// DeleteUser is the ID of the account to delete, DeletePolicy tells what happens with its posts
+	DeleteUser   string
+	DeletePolicy string
+	// ShowMigrations and MigrateDryRun leave the database as it is
+	ShowMigrations bool
+	MigrateDryRun  bool
+	// Backup and Restore are file names, the server must be stopped for them
+	Backup  string
+	Restore string
+	// Fsck checks the database of the stopped server, FsckRepair fixes the problems found
+	Fsck       bool
+	FsckRepair bool
+	// Export and Import are NDJSON file names, the export is written to stdout for -
+	Export          string
+	Import          string
+	ImportConflicts string
+}
+
+func (cmds *AdminCommands) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&cmds.ListUsers, "listUsers", false, "Shall we list all of the current users")
+	fs.BoolVar(&cmds.WipeUsers, "wipeUsers", false, "Will wipe all user data and likes, the posts are kept anonymized")
+	fs.BoolVar(&cmds.WipePosts, "wipePosts", false, "Will wipe all user posts")
+	fs.StringVar(&cmds.SetAdmin, "setAdmin", "", "Will set user with desired Id as Admin")
+	fs.StringVar(&cmds.PutOnEarth, "putOnEarth", "", "Set user rights back to the common peasant")
+	fs.StringVar(&cmds.DeleteUser, "deleteUser", "", "Delete the account with the ID, its likes and, by -deletePolicy, its posts")
+	fs.StringVar(&cmds.DeletePolicy, "deletePolicy", string(deletionDelete), "What -deleteUser does with the posts: delete or anonymize")
+	fs.BoolVar(&cmds.ShowMigrations, "migrations", false, "Show the applied and the pending database migrations")
+	fs.BoolVar(&cmds.MigrateDryRun, "migrateDryRun", false, "Try the pending database migrations and roll them back")
+	fs.StringVar(&cmds.Backup, "backup", "", "Write a snapshot of the database into the file")
+	fs.StringVar(&cmds.Restore, "restore", "", "Replace the database with the backup file")
+	fs.BoolVar(&cmds.Fsck, "fsck", false, "Check the users and the posts of the database for inconsistencies")
+	fs.BoolVar(&cmds.FsckRepair, "fsckRepair", false, "Check the database and repair the problems found in a single transaction")
+	fs.StringVar(&cmds.Export, "export", "", "Export the users, posts and pages into the NDJSON file, - for stdout")
+	fs.StringVar(&cmds.Import, "import", "", "Import the users, posts and pages from the NDJSON file")
+	fs.StringVar(&cmds.ImportConflicts, "importConflicts", string(conflictSkip), "What -import does with the existing users and pages: skip or overwrite")
+}
+
+// RunAdminCommands executes the requested commands, exit is true when the server shouldn't be started afterwards
+func RunAdminCommands(cfg *config.Config, cmds AdminCommands) (exit bool, err error) {
+	if !cmds.ListUsers && !cmds.WipeUsers && !cmds.WipePosts && len(cmds.SetAdmin) == 0 && len(cmds.PutOnEarth) == 0 &&
+		len(cmds.DeleteUser) == 0 && !cmds.ShowMigrations && !cmds.MigrateDryRun && len(cmds.Backup) == 0 &&
+		len(cmds.Restore) == 0 && !cmds.Fsck && !cmds.FsckRepair && len(cmds.Export) == 0 && len(cmds.Import) == 0 {
+		return false, nil
+	}
+	if len(cmds.Backup) > 0 {
+		return true, backupCommand(cfg.Database.Path, cmds.Backup, os.Stdout)
+	}
+	if len(cmds.Restore) > 0 {
+		if !confirm(os.Stdin, fmt.Sprintf("Are you sure you want to REPLACE %v with %v? (Yes or y)", cfg.Database.Path, cmds.Restore)) {
+			fmt.Println("Please type <yes> or <y> if you want to restore the database!")
+			return true, nil
+		}
+		replaced, err := restoreCommand(cfg.Database.Path, cmds.Restore, time.Now())
+		if err != nil {
+			return true, err
+		}
+		fmt.Printf("The database is restored from %v\n", cmds.Restore)
+		if len(replaced) > 0 {
+			fmt.Printf("The replaced database is kept in %v\n", replaced)
+		}
+		return true, nil
+	}
+	if cmds.Fsck || cmds.FsckRepair {
+		return true, fsckCommand(cfg.Database.Path, cmds.FsckRepair, os.Stdin, os.Stdout)
+	}
+	if cmds.ShowMigrations || cmds.MigrateDryRun {
+		return true, runMigrationCommands(cfg.Database.Path, cmds, os.Stdout)
+	}
+	err = InitDB(cfg.Database.Path)
+	if err != nil {
+		return true, err
+	}
+	db, err := bolt.Open(cfg.Database.Path, 0600, nil)
+	if err != nil {
+		return true, fmt.Errorf("couldn't open database: %w", err)
+	}
+	defer db.Close()
+
+	if cmds.ListUsers {
+		return true, listAllUsers(db)
+	}
+	if len(cmds.Export) > 0 {
+		return true, exportCommand(db, cmds.Export)
+	}
+	if len(cmds.Import) > 0 {
+		return true, importCommand(db, cmds.Import, cmds.ImportConflicts)
+	}
+	if cmds.WipeUsers {
+		if !confirm(os.Stdin, "Are you sure you want to DELETE ALL Users and their likes? (Yes or y)") {
+			fmt.Println("Please type <yes> or <y> if you want to clean user database!")
+			return true, nil
+		}
+		return true, wipeUsers(db)
+	}
+	if cmds.WipePosts {
+		if !confirm(os.Stdin, "Are you sure you want to DELETE ALL Posts? (Yes or y)") {
+			fmt.Println("Please type <yes> or <y> if you want to clean posts database!")
+			return true, nil
+		}
+		// the new posts reuse the IDs from 1, nothing may keep pointing at the wiped ones
+		return true, wipeBuckets(db, cPostsBucket, cPostsByTimeBucket, cUserPostsBucket, cLikesBucket, cUserLikesBucket)
+	}
+	if len(cmds.DeleteUser) > 0 {
+		if !confirm(os.Stdin, fmt.Sprintf("Are you sure you want to DELETE user %v? (Yes or y)", cmds.DeleteUser)) {
+			fmt.Println("Please type <yes> or <y> if you want to delete the user!")
+			return true, nil
+		}
+		return true, deleteUserCommand(db, cmds.DeleteUser, cmds.DeletePolicy)
+	}
+	if len(cmds.SetAdmin) > 0 {
+		err = setUserPrivilege(db, []byte(cmds.SetAdmin), ADMIN)
+		if err != nil {
+			return true, err
+		}
+	}
+	if len(cmds.PutOnEarth) > 0 {
+		err = setUserPrivilege(db, []byte(cmds.PutOnEarth), USER)
+		if err != nil {
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+func runMigrationCommands(path string, cmds AdminCommands, output io.Writer) error {
+	db, err := openDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if cmds.ShowMigrations {
+		if err := printMigrations(db, migrations, output); err != nil {
+			return err
+		}
+	}
+	if cmds.MigrateDryRun {
+		applied, err := migrate(db, migrations, true)
+		if err != nil {
+			return fmt.Errorf("dry run failed, nothing was changed: %w", err)
+		}
+		for _, m := range applied {
+			fmt.Fprintf(output, "Would apply %v: %v\n", m.version, m.description)
+		}
+		fmt.Fprintf(output, "Dry run succeeded, %v migrations would be applied, nothing was changed.\n", len(applied))
+	}
+	return nil
+}
+
+func exportCommand(db *bolt.DB, name string) error {
+	output := os.Stdout
+	if name != "-" {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	stats, err := exportData(db, output, time.Now())
+	if err != nil {
+		return fmt.Errorf("export failed: %w", err)
+	}
+	if name != "-" {
+		fmt.Printf("Exported %v into %v\n", stats, name)
+	}
+	return nil
+}
+
+func importCommand(db *bolt.DB, name string, conflicts string) error {
+	policy, err := parseConflictPolicy(conflicts)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stats, err := importData(db, file, policy)
+	if err != nil {
+		return fmt.Errorf("import failed, nothing was imported: %w", err)
+	}
+	fmt.Printf("Imported %v from %v\n", stats, name)
+	return nil
+}
+
+func confirm(input io.Reader, question string) bool {
+	fmt.Println(question)
+	text, _ := bufio.NewReader(input).ReadString('\n')
+	text = strings.ToLower(strings.TrimSpace(text))
+	return text == "yes" || text == "y"
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"tinywebserver/config"
+)
+
+const backupTimeFormat = "20060102T150405Z"
+
+// lockTimeout is how long the commands wait for the database file used by another process
+const lockTimeout = time.Second
+
+// backup writes a consistent snapshot of the database. It runs in a read transaction, so the
+// server keeps serving the writes meanwhile
+func (db *twsDB) backup(w io.Writer) (int64, error) {
+	var size int64
+	err := db.view("backup", func(tx *bolt.Tx) error {
+		var err error
+		size, err = tx.WriteTo(w)
+		return err
+	})
+	return size, err
+}
+
+// backupFileName orders the backups by time, e.g. tws-20220308T100400Z.db
+func backupFileName(t time.Time) string {
+	return "tws-" + t.UTC().Format(backupTimeFormat) + ".db"
+}
+
+// backupHandler streams the snapshot of the database to the admin
+func (env *environment) backupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+backupFileName(time.Now())+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	size, err := env.requestDB(r).backup(w)
+	if err != nil {
+		// the response has already started, breaking the connection tells the client the file is incomplete
+		requestLog(r).Error("backup failed", "bytes", size, "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	requestLog(r).Info("backup downloaded", "bytes", size)
+}
+
+// backupToFile writes the snapshot into a temporary file renamed once it's complete, so an
+// interrupted backup never looks like a finished one
+func backupToFile(db iDB, name string) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".backup-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	size, err := db.backup(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size, os.Rename(tmp.Name(), name)
+}
+
+// pruneBackups removes the oldest backups of the directory beyond keep
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "tws-*.db"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+func scheduledBackup(db iDB, cfg config.BackupConfig, now time.Time) (string, int64, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return "", 0, err
+	}
+	name := filepath.Join(cfg.Dir, backupFileName(now))
+	size, err := backupToFile(db, name)
+	if err != nil {
+		return "", 0, err
+	}
+	return name, size, pruneBackups(cfg.Dir, cfg.Keep)
+}
+
+// runScheduledBackups backs the database up every interval until the context is done
+func runScheduledBackups(ctx context.Context, db iDB, cfg config.BackupConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			name, size, err := scheduledBackup(db, cfg, now)
+			if err != nil {
+				dbBackups.Inc("failure")
+				serverLog.Error("scheduled backup failed", "dir", cfg.Dir, "err", err)
+				continue
+			}
+			dbBackups.Inc("success")
+			serverLog.Info("database backed up", "file", name, "bytes", size)
+		}
+	}
+}
+
+// openLocked opens the database unless the running server holds it
+func openLocked(path string, options *bolt.Options) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, options)
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%v is locked, is the server running? Stop it first", path)
+	}
+	return db, err
+}
+
+// backupCommand writes the snapshot of the stopped server's database into the file
+func backupCommand(path string, name string, output io.Writer) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := openLocked(path, &bolt.Options{ReadOnly: true, Timeout: lockTimeout})
+	if err != nil {
+		return fmt.Errorf("%w or download /admin/backup from the running server instead", err)
+	}
+	defer db.Close()
+	size, err := backupToFile(&twsDB{db: db}, name)
+	if err != nil {
+		return fmt.Errorf("couldn't back up the database: %w", err)
+	}
+	fmt.Fprintf(output, "Backed up %v bytes into %v\n", size, name)
+	return nil
+}
+
+// validateBackup checks that the file is a consistent database this release can run on
+func validateBackup(name string) error {
+	db, err := bolt.Open(name, 0600, &bolt.Options{ReadOnly: true, Timeout: lockTimeout})
+	if err != nil {
+		return fmt.Errorf("%v isn't a database: %w", name, err)
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		var corrupted error
+		for err := range tx.Check() {
+			if corrupted == nil {
+				corrupted = fmt.Errorf("%v is corrupted: %w", name, err)
+			}
+		}
+		if corrupted != nil {
+			return corrupted
+		}
+		version, err := schemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if latest := migrations[len(migrations)-1].version; version > latest {
+			return fmt.Errorf("%v has schema version %v, newer than the supported %v", name, version, latest)
+		}
+		// the buckets of the initial schema are created by the first migration
+		for _, bucketName := range []string{"PagesData", cUsersBucket, cPostsBucket} {
+			if version > 0 && getBucket(tx, bucketName) == nil {
+				return fmt.Errorf("%v has no %v bucket", name, bucketName)
+			}
+		}
+		return nil
+	})
+}
+
+// restoreCommand replaces the database of the stopped server with the backup. The replaced file is
+// kept next to it, e.g. tws.db.before-restore-20220308T100400Z
+func restoreCommand(path string, name string, now time.Time) (replaced string, err error) {
+	if err := validateBackup(name); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		db, err := openLocked(path, &bolt.Options{Timeout: lockTimeout})
+		if err != nil {
+			return "", err
+		}
+		db.Close()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".restore-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = copyFile(tmp, name)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't copy the backup: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		replaced = path + ".before-restore-" + now.UTC().Format(backupTimeFormat)
+		if err := os.Rename(path, replaced); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return replaced, err
+	}
+	return replaced, nil
+}
+
+func copyFile(dst *os.File, name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	return dst.Sync()
+}
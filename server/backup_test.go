@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"github.com/boltdb/bolt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"tinywebserver/config"
+	"tinywebserver/session"
+)
+
+func TestBackupHandler(t *testing.T) {
+	is := is.New(t)
+	env := environment{db: &stubDB{}, sessionManager: session.NewManager("memory", "twssessionid", 3600)}
+	mux := env.routes()
+	download := func(userData TwsUserData) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/admin/backup", nil)
+		loginTestUser(&env, req, userData)
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	is.Equal(download(defaultTestUserData).Code, http.StatusForbidden)
+
+	adminData := defaultTestUserData
+	adminData.AdminRight = ADMIN
+	rec := download(adminData)
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Body.String(), "snapshot")
+	is.True(strings.HasPrefix(rec.Header().Get("Content-Disposition"), `attachment; filename="tws-`))
+	is.Equal(rec.Header().Get("Cache-Control"), "no-store")
+}
+
+func readTestPage(is *is.I, path string, title string) string {
+	db, err := bolt.Open(path, 0600, nil)
+	is.NoErr(err)
+	defer db.Close()
+	page, err := (&twsDB{db: db}).GetPage(title)
+	is.NoErr(err)
+	return string(page)
+}
+
+func saveTestPage(is *is.I, path string, title string, body string) {
+	db, err := bolt.Open(path, 0600, nil)
+	is.NoErr(err)
+	defer db.Close()
+	is.NoErr((&twsDB{db: db}).SavePage(title, []byte(body)))
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data", "tws.db")
+	backupName := filepath.Join(dir, "backup.db")
+	is.NoErr(InitDB(path))
+	saveTestPage(is, path, "index", "backed up")
+
+	var output bytes.Buffer
+	is.NoErr(backupCommand(path, backupName, &output))
+	is.True(strings.Contains(output.String(), "into "+backupName))
+	saveTestPage(is, path, "index", "changed later")
+
+	// the commands don't touch the database of the running server
+	running, err := bolt.Open(path, 0600, nil)
+	is.NoErr(err)
+	err = backupCommand(path, filepath.Join(dir, "other.db"), &output)
+	is.True(err != nil && strings.Contains(err.Error(), "is locked"))
+	_, err = restoreCommand(path, backupName, time.Now())
+	is.True(err != nil && strings.Contains(err.Error(), "is locked"))
+	is.NoErr(running.Close())
+
+	replaced, err := restoreCommand(path, backupName, time.Date(2022, time.March, 8, 10, 4, 0, 0, time.UTC))
+	is.NoErr(err)
+	is.Equal(replaced, path+".before-restore-20220308T100400Z")
+	is.Equal(readTestPage(is, path, "index"), "backed up")
+	is.Equal(readTestPage(is, replaced, "index"), "changed later")
+}
+
+func TestValidateBackup(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+
+	garbage := filepath.Join(dir, "garbage.db")
+	is.NoErr(os.WriteFile(garbage, bytes.Repeat([]byte("tws"), 4096), 0600))
+	is.True(validateBackup(garbage) != nil)
+
+	newer := filepath.Join(dir, "newer.db")
+	is.NoErr(InitDB(newer))
+	db, err := bolt.Open(newer, 0600, nil)
+	is.NoErr(err)
+	is.NoErr(db.Update(func(tx *bolt.Tx) error { return setSchemaVersion(tx, 1000) }))
+	is.NoErr(db.Close())
+	err = validateBackup(newer)
+	is.True(err != nil && strings.Contains(err.Error(), "newer than the supported"))
+
+	_, err = restoreCommand(filepath.Join(dir, "tws.db"), garbage, time.Now())
+	is.True(err != nil)
+	_, err = os.Stat(filepath.Join(dir, "tws.db"))
+	is.True(os.IsNotExist(err))
+}
+
+func TestScheduledBackups(t *testing.T) {
+	is := is.New(t)
+	cfg := config.BackupConfig{Dir: filepath.Join(t.TempDir(), "backups"), Interval: time.Hour, Keep: 2}
+	start := time.Date(2022, time.March, 8, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		_, _, err := scheduledBackup(&stubDB{}, cfg, start.Add(time.Duration(i)*time.Hour))
+		is.NoErr(err)
+	}
+
+	names, err := filepath.Glob(filepath.Join(cfg.Dir, "*"))
+	is.NoErr(err)
+	is.Equal(len(names), 2)
+	is.Equal(filepath.Base(names[0]), "tws-20220308T110000Z.db")
+	is.Equal(filepath.Base(names[1]), "tws-20220308T120000Z.db")
+	content, err := os.ReadFile(names[1])
+	is.NoErr(err)
+	is.Equal(string(content), "snapshot")
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressionEncodings are the supported content codings in the order of preference
+var compressionEncodings = []string{"br", "gzip"}
+
+// encoder compresses the response body, it's reused for the next responses after Reset
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// The responses are compressed with the moderate levels, the static files are compressed once with the best ones
+var encoderPools = map[string]*sync.Pool{
+	"br": {New: func() interface{} { return brotli.NewWriterLevel(nil, 5) }},
+	"gzip": {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+}
+
+func newEncoder(encoding string, w io.Writer, best bool) encoder {
+	var enc encoder
+	switch {
+	case encoding == "br" && best:
+		enc = brotli.NewWriterLevel(w, brotli.BestCompression)
+	case encoding == "gzip" && best:
+		enc, _ = gzip.NewWriterLevel(w, gzip.BestCompression)
+	default:
+		enc = encoderPools[encoding].Get().(encoder)
+		enc.Reset(w)
+	}
+	return enc
+}
+
+func releaseEncoder(encoding string, enc encoder) {
+	enc.Reset(io.Discard)
+	encoderPools[encoding].Put(enc)
+}
+
+// negotiateEncoding picks the supported coding with the highest quality in Accept-Encoding, the ties are
+// resolved by the order of supported. Empty string means the response should stay uncompressed
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if len(coding) == 0 {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				parsed, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					parsed = 0
+				}
+				quality = parsed
+			}
+		}
+		qualities[coding] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, coding := range supported {
+		quality, ok := qualities[coding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+	}
+	return best
+}
+
+// compressible reports whether compressing the content type is worth it, the images and archives are
+// compressed already
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "application/javascript", mediaType == "application/json", mediaType == "application/x-ndjson",
+		mediaType == "application/xml", mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// addVary adds the header to Vary unless it's there already
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// compressResponses compresses the responses of at least minSize bytes with the coding negotiated by
+// Accept-Encoding. The responses which already have Content-Encoding, e.g. the precompressed static
+// files, are left untouched
+func compressResponses(minSize int) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header(), "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), compressionEncodings)
+			if len(encoding) == 0 || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter buffers the beginning of the body until minSize bytes are written, then it decides
+// whether to compress the response. The smaller responses are written as they are when the handler returns
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	status   int
+	buf      []byte
+	started  bool
+	encoder  encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.started {
+		if cw.encoder != nil {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (cw *compressWriter) shouldCompress() bool {
+	header := cw.Header()
+	if cw.status != http.StatusOK || len(header.Get("Content-Encoding")) > 0 || len(header.Get("Content-Range")) > 0 {
+		return false
+	}
+	if len(header.Get("Content-Type")) == 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	return compressible(header.Get("Content-Type"))
+}
+
+// start writes the header and the buffered body, compressing them if the response qualifies
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	if compress && cw.shouldCompress() {
+		header := cw.Header()
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		// the compressed body differs from the one the strong ETag was computed for
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		cw.encoder = newEncoder(cw.encoding, cw.ResponseWriter, false)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// Flush sends the buffered body at once, the streamed responses are compressed only if enough was buffered
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.start(len(cw.buf) >= cw.minSize)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) close() {
+	if !cw.started && cw.status != 0 {
+		cw.start(false)
+	}
+	if cw.encoder != nil {
+		if err := cw.encoder.Close(); err != nil {
+			serverLog.Warn("couldn't finish compressed response", "encoding", cw.encoding, "err", err)
+		}
+		releaseEncoder(cw.encoding, cw.encoder)
+		cw.encoder = nil
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/matryer/is"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"tinywebserver/config"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"identity", ""},
+		{"*", "br"},
+		{"*;q=0.1, gzip;q=0.5", "gzip"},
+		{"BR", "br"},
+		{"deflate, gzip;q=garbage", ""},
+	}
+	for _, tt := range tests {
+		if encoding := negotiateEncoding(tt.acceptEncoding, compressionEncodings); encoding != tt.expected {
+			t.Errorf("encoding negotiated for [%v] should be [%v], got [%v]", tt.acceptEncoding, tt.expected, encoding)
+		}
+	}
+}
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gz
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return string(body)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decoded)
+}
+
+func TestCompressResponses(t *testing.T) {
+	is := is.New(t)
+	page := "<html>" + strings.Repeat("<p>tiny webserver</p>", 100) + "</html>"
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("ETag", `"page"`)
+			io.WriteString(w, page[:10])
+			io.WriteString(w, page[10:])
+		case "/small":
+			io.WriteString(w, "<p>small</p>")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(bytes.Repeat([]byte{0}, 4096))
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(bytes.Repeat([]byte{1}, 4096))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, page)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	compressed := compressResponses(1024)(http.HandlerFunc(handler))
+	serve := func(method, path, acceptEncoding string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		compressed.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, encoding := range compressionEncodings {
+		rec := serve(http.MethodGet, "/page", encoding)
+		is.Equal(rec.Code, http.StatusOK)
+		is.Equal(rec.Header().Get("Content-Encoding"), encoding)
+		is.Equal(rec.Header().Get("Vary"), "Accept-Encoding")
+		is.Equal(rec.Header().Get("ETag"), `W/"page"`)
+		is.True(strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html"))
+		is.True(rec.Body.Len() < len(page))
+		is.Equal(decompress(t, encoding, rec.Body.Bytes()), page)
+	}
+
+	rec := serve(http.MethodGet, "/page", "")
+	is.Equal(rec.Header().Get("Content-Encoding"), "")
+	is.Equal(rec.Header().Get("Vary"), "Accept-Encoding")
+	is.Equal(rec.Body.String(), page)
+
+	rec = serve(http.MethodGet, "/missing", "gzip")
+	is.Equal(rec.Code, http.StatusNotFound)
+	is.Equal(rec.Header().Get("Content-Encoding"), "")
+
+	for _, path := range []string{"/small", "/image"} {
+		rec = serve(http.MethodGet, path, "gzip, br")
+		is.Equal(rec.Code, http.StatusOK)
+		is.Equal(rec.Header().Get("Content-Encoding"), "")
+		is.Equal(rec.Header().Get("Vary"), "Accept-Encoding")
+	}
+
+	rec = serve(http.MethodGet, "/encoded", "br")
+	is.Equal(rec.Header().Get("Content-Encoding"), "gzip")
+	is.Equal(rec.Body.Bytes(), bytes.Repeat([]byte{1}, 4096))
+
+	rec = serve(http.MethodGet, "/empty", "br")
+	is.Equal(rec.Code, http.StatusNoContent)
+	is.Equal(rec.Body.Len(), 0)
+
+	rec = serve(http.MethodHead, "/page", "br")
+	is.Equal(rec.Header().Get("Content-Encoding"), "")
+}
+
+func TestPrecompressedStaticAssets(t *testing.T) {
+	is := is.New(t)
+	css := strings.Repeat(".button { color: red; }\n", 200)
+	static, err := newStaticAssets(fstest.MapFS{
+		"frontend/css/bulma.css":    {Data: []byte(css)},
+		"frontend/css/bulma.css.gz": {Data: []byte("prebuilt")},
+		"tmpl/css/tws-style.css":    {Data: []byte("body {}")},
+	}, true)
+	is.NoErr(err)
+	is.NoErr(static.precompress(1024))
+	is.Equal(static.files["frontend/css/bulma.css"].encodings(), []string{"br", "gzip"})
+	is.Equal(len(static.files["tmpl/css/tws-style.css"].encoded), 0)
+
+	env := environment{db: &stubDB{}, static: static, compression: config.CompressionConfig{Enabled: true, MinSize: 1024}}
+	rec := serveStatic(&env, "/frontend/css/bulma.css", http.Header{"Accept-Encoding": {"gzip, br"}})
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Header().Get("Content-Encoding"), "br")
+	is.Equal(rec.Header().Get("Vary"), "Accept-Encoding")
+	is.True(strings.HasPrefix(rec.Header().Get("Content-Type"), "text/css"))
+	is.Equal(decompress(t, "br", rec.Body.Bytes()), css)
+	etag := rec.Header().Get("ETag")
+	is.True(strings.HasSuffix(etag, `-br"`))
+
+	rec = serveStatic(&env, "/frontend/css/bulma.css", http.Header{"Accept-Encoding": {"br"}, "If-None-Match": {etag}})
+	is.Equal(rec.Code, http.StatusNotModified)
+
+	// the files compressed at build time are served as they are
+	rec = serveStatic(&env, "/frontend/css/bulma.css", http.Header{"Accept-Encoding": {"gzip"}})
+	is.Equal(rec.Header().Get("Content-Encoding"), "gzip")
+	is.Equal(rec.Body.String(), "prebuilt")
+
+	rec = serveStatic(&env, "/frontend/css/bulma.css", nil)
+	is.Equal(rec.Header().Get("Content-Encoding"), "")
+	is.Equal(rec.Body.String(), css)
+}
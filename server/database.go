@@ -1,32 +1,74 @@
 package server
 
 import (
-	"bufio"
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
-	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
+	"tinywebserver/logger"
 	"tinywebserver/utils"
 )
 
 type twsDB struct {
-	db *bolt.DB
+	db  *bolt.DB
+	log *logger.Logger
+}
+
+// withContext returns the database bound to the request logger, so the database records carry
+// the request ID
+func (db *twsDB) withContext(ctx context.Context) iDB {
+	return &twsDB{db: db.db, log: logger.FromContext(ctx, serverLog)}
+}
+
+// view runs the read-only transaction and records its duration under the method name
+func (db *twsDB) view(method string, fn func(tx *bolt.Tx) error) error {
+	start := time.Now()
+	defer func() { dbTransactionDuration.Observe(time.Since(start).Seconds(), method) }()
+	return db.db.View(fn)
+}
+
+// update runs the read-write transaction and records its duration under the method name
+func (db *twsDB) update(method string, fn func(tx *bolt.Tx) error) error {
+	start := time.Now()
+	defer func() { dbTransactionDuration.Observe(time.Since(start).Seconds(), method) }()
+	return db.db.Update(fn)
+}
+
+func (db *twsDB) logger() *logger.Logger {
+	if db.log == nil {
+		return serverLog
+	}
+	return db.log
+}
+
+// checkHealth verifies that the database is opened and all of the buckets are in place
+func (db *twsDB) checkHealth() error {
+	return db.view("checkHealth", func(tx *bolt.Tx) error {
+		for _, bucketName := range []string{"PagesData", cUsersBucket, cPostsBucket, cPostsByTimeBucket, cUserPostsBucket, cLikesBucket, cUserLikesBucket} {
+			if getBucket(tx, bucketName) == nil {
+				return fmt.Errorf("%v bucket doesn't exist", bucketName)
+			}
+		}
+		return nil
+	})
 }
 
 type dbUserData struct {
 	AvatarUrl  string
 	AdminRight UserRight
-	PostsIDs   []int
+	// Locale is the language tag of the UI chosen by the user, empty follows the browser
+	Locale string `json:",omitempty"`
 }
 
 type dbPost struct {
 	postId       int `json:"-"`
 	Text         string
-	Likes        []string
+	LikesCount   int    //Kept up to date with the likes of the post in cLikesBucket
 	CreationDate []byte //Must be specified in twsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
 	CreatorId    []byte
 	RepostId     int
@@ -35,7 +77,10 @@ type dbPost struct {
 const (
 	cUsersBucket = "Users"
 	cPostsBucket = "Posts"
-	cUserID      = "userID"
+	// cUserPostsBucket has a nested bucket for every user who posted, keyed by the user ID. The keys
+	// of the nested buckets are the IDs of the user's posts and the values are empty
+	cUserPostsBucket = "UserPosts"
+	cUserID          = "userID"
 )
 
 const (
@@ -45,78 +90,44 @@ const (
 	cPostNotExistError        = "post doesn't exist"
 )
 
+var (
+	errUserNotExist = errors.New(cUserNotExistError)
+	errPostNotExist = errors.New(cPostNotExistError)
+)
+
 func createBucketIfNotExistsOrDie(bucketName []byte, db *bolt.DB) {
 	err := db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists(bucketName)
 		return err
 	})
 	if err != nil {
-		log.Fatal(err)
+		serverLog.Fatal("couldn't create bucket", "bucket", bucketName, "err", err)
 	}
 }
 
-func InitDB() {
-	_, err := os.Stat("data")
-	if os.IsNotExist(err) {
-		err = os.Mkdir("data", 0700)
-		if err != nil {
-			log.Fatal(err)
-		}
+// openDB opens the database file, its directory is created when missing
+func openDB(path string) (*bolt.DB, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create data directory: %w", err)
 	}
-	db, err := bolt.Open("data/tws.db", 0600, nil)
+	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("couldn't open database: %w", err)
 	}
-	defer db.Close()
+	return db, nil
+}
 
-	createBucketIfNotExistsOrDie([]byte("PagesData"), db)
-	createBucketIfNotExistsOrDie([]byte("Users"), db)
-	createBucketIfNotExistsOrDie([]byte("Posts"), db)
-
-	listUsers := flag.Bool("listUsers", false, "Shall we list all of the current users")
-	wipeUsers := flag.Bool("wipeUsers", false, "Will wipe all user data")
-	wipePosts := flag.Bool("wipePosts", false, "Will wipe all user posts")
-	setAdmin := flag.String("setAdmin", "", "Will set user with desired Id as Admin")
-	setUser := flag.String("putOnEarth", "", "Set user rights back to the common peasant")
-	flag.Parse()
-	if *listUsers {
-		listAllUsers(db)
-		os.Exit(0)
-	}
-	if *wipeUsers {
-		fmt.Println("Are you sure you want to DELETE ALL Users? (Yes or y)")
-		reader := bufio.NewReader(os.Stdin)
-		text, _ := reader.ReadString('\n')
-		text = strings.Replace(text, "\r\n", "", -1)
-		text = strings.Replace(text, "\n", "", -1)
-		text = strings.ToLower(text)
-		if strings.Compare(text, "yes") == 0 || strings.Compare(text, "y") == 0 {
-			wipeBucket(db, []byte("Users"))
-		} else {
-			fmt.Println("Please type <yes> or <y> if you want to clean user database!")
-		}
-		os.Exit(0)
-	}
-	if *wipePosts {
-		fmt.Println("Are you sure you want to DELETE ALL Posts? (Yes or y)")
-		reader := bufio.NewReader(os.Stdin)
-		text, _ := reader.ReadString('\n')
-		text = strings.Replace(text, "\r\n", "", -1)
-		text = strings.Replace(text, "\n", "", -1)
-		text = strings.ToLower(text)
-		if strings.Compare(text, "yes") == 0 || strings.Compare(text, "y") == 0 {
-			wipeBucket(db, []byte("Posts"))
-		} else {
-			fmt.Println("Please type <yes> or <y> if you want to clean user database!")
-		}
-		os.Exit(0)
-	}
-	if len(*setAdmin) > 0 {
-		setUserPrivilege(db, []byte(*setAdmin), ADMIN)
-	}
-	if len(*setUser) > 0 {
-		setUserPrivilege(db, []byte(*setUser), USER)
+// InitDB creates the database file if it doesn't exist yet and applies the pending migrations
+func InitDB(path string) error {
+	db, err := openDB(path)
+	if err != nil {
+		return err
 	}
+	defer db.Close()
+
+	_, err = migrate(db, migrations, false)
+	return err
 }
 
 func getBucket(tx *bolt.Tx, bucketName string) *bolt.Bucket {
@@ -125,7 +136,7 @@ func getBucket(tx *bolt.Tx, bucketName string) *bolt.Bucket {
 
 func (db *twsDB) GetPage(title string) ([]byte, error) {
 	var resultData []byte
-	err := db.db.View(func(tx *bolt.Tx) error {
+	err := db.view("GetPage", func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("PagesData"))
 		getResult := b.Get([]byte(title))
 		resultData = append(resultData, getResult...)
@@ -136,28 +147,123 @@ func (db *twsDB) GetPage(title string) ([]byte, error) {
 }
 
 func (db *twsDB) SavePage(title string, data []byte) error {
-	return db.db.Update(func(tx *bolt.Tx) error {
+	return db.update("SavePage", func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("PagesData"))
 		return bucket.Put([]byte(title), data)
 	})
 }
 
+// userPostsBucket returns the nested bucket with the post IDs of the user, it's nil when the user
+// has never posted
+func userPostsBucket(tx *bolt.Tx, ownerID []byte) *bolt.Bucket {
+	userPosts := getBucket(tx, cUserPostsBucket)
+	if userPosts == nil {
+		return nil
+	}
+	return userPosts.Bucket(ownerID)
+}
+
+func checkUserExists(tx *bolt.Tx, ownerID []byte) error {
+	usersBucket := tx.Bucket([]byte("Users"))
+	if usersBucket == nil {
+		return fmt.Errorf("users bucket doesn't exists")
+	}
+	if usersBucket.Get(ownerID) == nil {
+		return fmt.Errorf("user with the owner id of %s doesn't exist", ownerID)
+	}
+	return nil
+}
+
 func appendPostToUser(tx *bolt.Tx, ownerID []byte, postID int) error {
-	appendFunc := func(user *dbUserData) {
-		user.PostsIDs = append(user.PostsIDs, postID)
+	if err := checkUserExists(tx, ownerID); err != nil {
+		return err
+	}
+	userPosts := getBucket(tx, cUserPostsBucket)
+	if userPosts == nil {
+		return fmt.Errorf(cUserPostsBucket + " bucket doesn't exist")
 	}
-	return updateUser(tx, ownerID, appendFunc)
+	bucket, err := userPosts.CreateBucketIfNotExists(ownerID)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(utils.Itob(postID), []byte{})
 }
 
 func removePostFromUser(tx *bolt.Tx, ownerID []byte, postID int) error {
-	removeFunc := func(user *dbUserData) {
-		i, _ := utils.FindInt(user.PostsIDs, postID)
-		if i >= 0 {
-			//TODO: Quite possible place for a bottleneck, might need rethinking in the future
-			user.PostsIDs = append(user.PostsIDs[:i], user.PostsIDs[i+1:]...)
+	if err := checkUserExists(tx, ownerID); err != nil {
+		return err
+	}
+	bucket := userPostsBucket(tx, ownerID)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete(utils.Itob(postID))
+}
+
+// userPostIDs returns the IDs of the user's posts, the oldest first
+func userPostIDs(tx *bolt.Tx, ownerID []byte) []int {
+	var postIDs []int
+	bucket := userPostsBucket(tx, ownerID)
+	if bucket == nil {
+		return postIDs
+	}
+	cursor := bucket.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		postIDs = append(postIDs, utils.Btoi(key))
+	}
+	return postIDs
+}
+
+// moveUserPostsToBuckets is the migration which moves the PostsIDs arrays of the users, which were
+// rewritten on every post, into the nested buckets of UserPosts
+func moveUserPostsToBuckets(tx *bolt.Tx) error {
+	userPosts, err := tx.CreateBucketIfNotExists([]byte(cUserPostsBucket))
+	if err != nil {
+		return fmt.Errorf("couldn't create %v bucket: %w", cUserPostsBucket, err)
+	}
+	usersBucket := getBucket(tx, cUsersBucket)
+	// the bucket can't be changed while it's iterated, the users are collected first
+	users := map[string]map[string]json.RawMessage{}
+	err = usersBucket.ForEach(func(k, v []byte) error {
+		// the other fields are kept as they are
+		user := map[string]json.RawMessage{}
+		if err := json.Unmarshal(v, &user); err != nil {
+			return fmt.Errorf("user %s: %w", k, err)
+		}
+		users[string(k)] = user
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for userID, user := range users {
+		var postIDs []int
+		if value, ok := user["PostsIDs"]; ok {
+			if err := json.Unmarshal(value, &postIDs); err != nil {
+				return fmt.Errorf("user %v: %w", userID, err)
+			}
+		}
+		if len(postIDs) > 0 {
+			bucket, err := userPosts.CreateBucketIfNotExists([]byte(userID))
+			if err != nil {
+				return err
+			}
+			for _, postID := range postIDs {
+				if err := bucket.Put(utils.Itob(postID), []byte{}); err != nil {
+					return err
+				}
+			}
+		}
+		delete(user, "PostsIDs")
+		buf, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+		if err := usersBucket.Put([]byte(userID), buf); err != nil {
+			return err
 		}
 	}
-	return updateUser(tx, ownerID, removeFunc)
+	return nil
 }
 
 func updateUser(tx *bolt.Tx, ownerID []byte, updateFunc func(user *dbUserData)) error {
@@ -184,12 +290,20 @@ func updateUser(tx *bolt.Tx, ownerID []byte, updateFunc func(user *dbUserData))
 	return usersBucket.Put(ownerID, userBuf)
 }
 
+func (db *twsDB) setUserLocale(userID []byte, locale string) error {
+	return db.update("setUserLocale", func(tx *bolt.Tx) error {
+		return updateUser(tx, userID, func(user *dbUserData) {
+			user.Locale = locale
+		})
+	})
+}
+
 func (db *twsDB) saveUserPost(ownerID []byte, postText string) (postID int, err error) {
-	log.Println("saveUserPost()")
+	db.logger().Debug("saving user post", "owner_id", ownerID)
 	if len(postText) == 0 {
 		return 0, fmt.Errorf("post text is empty\n")
 	}
-	err = db.db.Update(func(tx *bolt.Tx) error {
+	err = db.update("saveUserPost", func(tx *bolt.Tx) error {
 		//Prepare post for Posts bucket
 		postsBucket := tx.Bucket([]byte("Posts"))
 		if postsBucket == nil {
@@ -220,20 +334,23 @@ func (db *twsDB) saveUserPost(ownerID []byte, postText string) (postID int, err
 		if err != nil {
 			removePostErr := removePostFromUser(tx, ownerID, postID)
 			if removePostErr != nil {
-				log.Printf("couldn't roll back appended to the user post")
+				db.logger().Error("couldn't roll back appended to the user post", "post_id", postID, "err", removePostErr)
 			}
+			return err
 		}
-		return err
+		return indexPost(tx, postID, post)
 	})
 
 	if err != nil {
 		postID = 0
+	} else {
+		postsCreated.Inc(postTypeLabel(PostType_Post))
 	}
 	return
 }
 
 func (db *twsDB) repostUserPost(postToRepostId []byte, reposterId []byte, reposterText string) (resultPostId int, err error) {
-	err = db.db.Update(func(tx *bolt.Tx) error {
+	err = db.update("repostUserPost", func(tx *bolt.Tx) error {
 		postsBucket := tx.Bucket([]byte(cPostsBucket))
 		if postsBucket == nil {
 			return fmt.Errorf(cPostsBucketNotExistError)
@@ -267,18 +384,29 @@ func (db *twsDB) repostUserPost(postToRepostId []byte, reposterId []byte, repost
 		if err != nil {
 			removePostErr := removePostFromUser(tx, reposterId, newPostId)
 			if removePostErr != nil {
-				log.Printf("couldn't roll back appended to the user post")
+				db.logger().Error("couldn't roll back appended to the user post", "post_id", newPostId, "err", removePostErr)
 			}
-		} else {
-			resultPostId = newPostId
+			return err
 		}
-		return err
+		if err := indexPost(tx, newPostId, newPost); err != nil {
+			return err
+		}
+		resultPostId = newPostId
+		return nil
 	})
+	if err == nil {
+		repostType := PostType_Repost
+		if len(reposterText) > 0 {
+			repostType = PostType_Quote
+		}
+		postsCreated.Inc(postTypeLabel(repostType))
+	}
 	return
 }
 
 func (db *twsDB) toggleLikeOnUserPost(ownerID []byte, postID int, likeOwner string) error {
-	return db.db.Update(func(tx *bolt.Tx) error {
+	likeAction := "like"
+	err := db.update("toggleLikeOnUserPost", func(tx *bolt.Tx) error {
 		postsBucket := tx.Bucket([]byte("Posts"))
 		if postsBucket == nil {
 			return fmt.Errorf("posts bucket doesn't exists")
@@ -295,13 +423,16 @@ func (db *twsDB) toggleLikeOnUserPost(ownerID []byte, postID int, likeOwner stri
 			return err
 		}
 
-		copyIndex, _ := utils.FindString(post.Likes, likeOwner)
-		if copyIndex >= 0 {
-			lastElem := len(post.Likes) - 1
-			post.Likes[copyIndex] = post.Likes[lastElem]
-			post.Likes = post.Likes[:lastElem]
+		if likes := postLikesBucket(tx, postID); likes != nil && likes.Get([]byte(likeOwner)) != nil {
+			likeAction = "unlike"
+			err = removeLike(tx, postID, likeOwner)
+			post.LikesCount--
 		} else {
-			post.Likes = append(post.Likes, likeOwner)
+			err = addLike(tx, postID, likeOwner)
+			post.LikesCount++
+		}
+		if err != nil {
+			return err
 		}
 
 		buf, err = json.Marshal(post)
@@ -310,27 +441,43 @@ func (db *twsDB) toggleLikeOnUserPost(ownerID []byte, postID int, likeOwner stri
 		}
 		return postsBucket.Put(postIDb, buf)
 	})
+	if err == nil {
+		likesToggled.Inc(likeAction)
+	}
+	return err
 }
 
 func (db *twsDB) deleteUserPost(ownerID []byte, postID int) error {
-	log.Printf("twsDB::deleteUserPost ownerId - %s, postID - %v", ownerID, postID)
-	return db.db.Update(func(tx *bolt.Tx) error {
+	db.logger().Debug("deleting user post", "owner_id", ownerID, "post_id", postID)
+	return db.update("deleteUserPost", func(tx *bolt.Tx) error {
 		postsBucket := tx.Bucket([]byte("Posts"))
 		if postsBucket == nil {
 			return fmt.Errorf("posts bucket doesn't exists")
 		}
+		if buf := postsBucket.Get(utils.Itob(postID)); buf != nil {
+			post := dbPost{}
+			if err := json.Unmarshal(buf, &post); err != nil {
+				return err
+			}
+			if err := unindexPost(tx, postID, post); err != nil {
+				return err
+			}
+		}
+		if err := deletePostLikes(tx, postID); err != nil {
+			return err
+		}
 		err := postsBucket.Delete(utils.Itob(postID))
 		if err != nil {
 			return err
 		}
 
-		err = removePostFromUser(tx, ownerID, postID)
-		return err
+		// the reposts and quotes of the post are kept, they show it as deleted
+		return removePostFromUser(tx, ownerID, postID)
 	})
 }
 
 func (db *twsDB) getLatestUserPosts(ownerID []byte, maxPostsToGet int, lastKey int) (posts []dbPost, err error) {
-	err = db.db.View(func(tx *bolt.Tx) error {
+	err = db.view("getLatestUserPosts", func(tx *bolt.Tx) error {
 		usersBucket := tx.Bucket([]byte("Users"))
 		if usersBucket == nil {
 			return fmt.Errorf("users bucket doesn't exists\n")
@@ -339,29 +486,31 @@ func (db *twsDB) getLatestUserPosts(ownerID []byte, maxPostsToGet int, lastKey i
 		if userBuf == nil {
 			return fmt.Errorf("user with id %s doesn't exists", ownerID)
 		}
-		user := &dbUserData{}
-		err = json.Unmarshal(userBuf, user)
-		if err != nil {
-			return err
-		}
 
 		postsBucket := tx.Bucket([]byte("Posts"))
 		if postsBucket == nil {
 			return fmt.Errorf("posts bucket doesn't exists\n")
 		}
 
-		i := len(user.PostsIDs) - 1
+		bucket := userPostsBucket(tx, ownerID)
+		if bucket == nil && lastKey > 0 {
+			return fmt.Errorf("sorry, current id doesn't exist")
+		} else if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		key, _ := cursor.Last()
 		if lastKey > 0 {
-			i, _ = utils.FindInt(user.PostsIDs, lastKey)
-			if i < 0 {
+			key, _ = cursor.Seek(utils.Itob(lastKey))
+			if key == nil || utils.Btoi(key) != lastKey {
 				return fmt.Errorf("sorry, current id doesn't exist")
 			}
 		}
-		for postCount := 0; i >= 0 && postCount < maxPostsToGet; i-- {
-			postId := user.PostsIDs[i]
+		for postCount := 0; key != nil && postCount < maxPostsToGet; key, _ = cursor.Prev() {
+			postId := utils.Btoi(key)
 			val := postsBucket.Get(utils.Itob(postId))
 			if val == nil {
-				log.Printf("post id [%v] is missing from posts bucket!", postId)
+				db.logger().Warn("post id is missing from posts bucket, -fsck repairs it", "post_id", postId, "owner_id", ownerID)
 				continue
 			}
 			post := &dbPost{}
@@ -377,18 +526,30 @@ func (db *twsDB) getLatestUserPosts(ownerID []byte, maxPostsToGet int, lastKey i
 		return nil
 	})
 
-	log.Printf("tws::getLatestUserPosts() input ownerID - %s, maxPostsToGet - %v\nresult - %+v\n", ownerID, maxPostsToGet, posts)
+	db.logger().Debug("loaded latest user posts", "owner_id", ownerID, "max_posts", maxPostsToGet, "posts", len(posts))
 	return posts, err
 }
 
+// getUserPostIDs returns the IDs of the user's posts, the oldest first
+func (db *twsDB) getUserPostIDs(userID string) (postIDs []int, err error) {
+	err = db.view("getUserPostIDs", func(tx *bolt.Tx) error {
+		postIDs = userPostIDs(tx, []byte(userID))
+		return nil
+	})
+	return
+}
+
 func (db *twsDB) getUserPost(postID int) (post dbPost, err error) {
-	err = db.db.View(func(tx *bolt.Tx) error {
+	err = db.view("getUserPost", func(tx *bolt.Tx) error {
 		postsBucket := tx.Bucket([]byte("Posts"))
 		if postsBucket == nil {
 			return fmt.Errorf("posts bucket doesn't exist")
 		}
 
 		postJson := postsBucket.Get(utils.Itob(postID))
+		if postJson == nil {
+			return errPostNotExist
+		}
 		err = json.Unmarshal(postJson, &post)
 		if err != nil {
 			return err
@@ -404,7 +565,7 @@ func (db *twsDB) getUserPosts(postsId []int) ([]dbPost, error) {
 		return nil, fmt.Errorf("no posts were requested")
 	}
 	posts := make([]dbPost, len(postsId))
-	err := db.db.View(func(tx *bolt.Tx) error {
+	err := db.view("getUserPosts", func(tx *bolt.Tx) error {
 		postsBucket := tx.Bucket([]byte(cPostsBucket))
 		if postsBucket == nil {
 			return fmt.Errorf(cPostsBucketNotExistError)
@@ -427,7 +588,32 @@ func (db *twsDB) getUserPosts(postsId []int) ([]dbPost, error) {
 		posts = nil
 	}
 
-	log.Printf("twsDB::getUserPosts will return %+v", posts)
+	db.logger().Debug("loaded user posts", "requested", len(postsId), "posts", len(posts))
+	return posts, err
+}
+
+// getLikedPosts returns the posts liked by the user in the order they were created. The IDs are read
+// from the user's bucket in UserLikes, so only the posts the user liked are loaded
+func (db *twsDB) getLikedPosts(userID string) (posts []dbPost, err error) {
+	err = db.view("getLikedPosts", func(tx *bolt.Tx) error {
+		postsBucket := getBucket(tx, cPostsBucket)
+		if postsBucket == nil {
+			return fmt.Errorf(cPostsBucketNotExistError)
+		}
+		for _, postID := range userLikedPostIDs(tx, userID) {
+			buf := postsBucket.Get(utils.Itob(postID))
+			if buf == nil {
+				continue
+			}
+			post := dbPost{postId: postID}
+			if err := json.Unmarshal(buf, &post); err != nil {
+				return err
+			}
+			posts = append(posts, post)
+		}
+		return nil
+	})
+	db.logger().Debug("loaded liked posts", "user_id", userID, "posts", len(posts))
 	return posts, err
 }
 
@@ -435,25 +621,25 @@ func (db *twsDB) getUser(userId string) (dbUser dbUserData, err error) {
 	if len(userId) == 0 {
 		return dbUserData{}, fmt.Errorf("userId is empty")
 	}
-	err = db.db.View(func(tx *bolt.Tx) error {
+	err = db.view("getUser", func(tx *bolt.Tx) error {
 		bucket := getBucket(tx, cUsersBucket)
 		if bucket == nil {
 			return fmt.Errorf(cUsersBucketNotExistError)
 		}
 		buf := bucket.Get([]byte(userId))
 		if buf == nil {
-			return fmt.Errorf(cUserNotExistError)
+			return errUserNotExist
 		}
 		return json.Unmarshal(buf, &dbUser)
 	})
-	log.Printf("twsDB::getUser() will return %+v", dbUser)
+	db.logger().Debug("loaded user", "user_id", userId, "err", err)
 	return
 }
 
 // SyncUser Add user if doesn't exists or update current database data
 func (db *twsDB) SyncUser(userData TwsUserData) (TwsUserData, error) {
 	userResultData := userData
-	err := db.db.Update(func(tx *bolt.Tx) error {
+	err := db.update("SyncUser", func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("Users"))
 		var dbUser dbUserData
 		userDBData := bucket.Get([]byte(userData.Id))
@@ -464,13 +650,14 @@ func (db *twsDB) SyncUser(userData TwsUserData) (TwsUserData, error) {
 			}
 			//Fields that we want to pull from the database
 			userResultData.AdminRight = dbUser.AdminRight
+			userResultData.Locale = dbUser.Locale
 		}
 		//Fields that we want to overwrite
 		dbUser.AvatarUrl = userData.AvatarUrl
 
 		dbByteData, err := json.Marshal(dbUser)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		return bucket.Put([]byte(userData.Id), dbByteData)
 	})
@@ -478,7 +665,7 @@ func (db *twsDB) SyncUser(userData TwsUserData) (TwsUserData, error) {
 		return TwsUserData{}, err
 	}
 
-	log.Printf("twsDB::SyncUser() result %+v\n", userResultData)
+	db.logger().Debug("synced user", "user_id", userResultData.Id, "admin_right", userResultData.AdminRight)
 	return userResultData, nil
 }
 
@@ -493,7 +680,7 @@ func setUserPrivilege(db *bolt.DB, userId []byte, userRight UserRight) error {
 		var dbUser dbUserData
 		err := json.Unmarshal(user, &dbUser)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 
 		dbUser.AdminRight = userRight
@@ -504,7 +691,6 @@ func setUserPrivilege(db *bolt.DB, userId []byte, userRight UserRight) error {
 
 func listAllUsers(db *bolt.DB) error {
 	return db.View(func(tx *bolt.Tx) error {
-		log.Println("Gonna list all of the current users")
 		bucket := tx.Bucket([]byte("Users"))
 		cursor := bucket.Cursor()
 
@@ -522,22 +708,73 @@ func listAllUsers(db *bolt.DB) error {
 	})
 }
 
-func wipeBucket(db *bolt.DB, bucketName []byte) error {
+// wipeBuckets empties the buckets in a single transaction, so a bucket and the ones indexing it,
+// e.g. Posts and PostsByTime, are never wiped apart
+func wipeBuckets(db *bolt.DB, bucketNames ...string) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		return recreateBuckets(tx, bucketNames...)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("All of the %s successfully deleted!\n", strings.Join(bucketNames, ", "))
+	return nil
+}
+
+func recreateBuckets(tx *bolt.Tx, bucketNames ...string) error {
+	for _, bucketName := range bucketNames {
+		if tx.Bucket([]byte(bucketName)) != nil {
+			if err := tx.DeleteBucket([]byte(bucketName)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket([]byte(bucketName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wipeUsers deletes all of the accounts in a single transaction. Their posts stay anonymized, as with
+// the anonymize deletion policy, and all of the likes go with the users who left them
+func wipeUsers(db *bolt.DB) error {
 	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
+		if err := recreateBuckets(tx, cUsersBucket, cUserPostsBucket, cLikesBucket, cUserLikesBucket); err != nil {
+			return err
+		}
+		postsBucket := getBucket(tx, cPostsBucket)
+		if postsBucket == nil {
+			return fmt.Errorf(cPostsBucketNotExistError)
+		}
+		// the bucket can't be changed while it's iterated, the posts are collected first
+		posts := map[int]dbPost{}
+		err := postsBucket.ForEach(func(k, v []byte) error {
+			post := dbPost{}
+			if err := json.Unmarshal(v, &post); err != nil {
+				return fmt.Errorf("post %x: %w", k, err)
+			}
+			posts[utils.Btoi(k)] = post
+			return nil
+		})
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		for postID, post := range posts {
+			post.CreatorId = nil
+			post.LikesCount = 0
+			buf, err := json.Marshal(post)
+			if err != nil {
+				return err
+			}
+			if err := postsBucket.Put(utils.Itob(postID), buf); err != nil {
+				return err
+			}
 		}
-		fmt.Println("All users successfully deleted!")
 		return nil
 	})
 	if err != nil {
-		log.Fatal(err)
 		return err
 	}
-
-	return db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucket([]byte("Users"))
-		return err
-	})
+	fmt.Println("All of the Users and their likes successfully deleted, their posts are anonymized!")
+	return nil
 }
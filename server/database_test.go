@@ -74,6 +74,10 @@ func TestSavePost(t *testing.T) {
 	//Test successful save scenario
 	createBucketIfNotExistsOrDie([]byte("Users"), testDB.db)
 	createBucketIfNotExistsOrDie([]byte("Posts"), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cPostsByTimeBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cUserPostsBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cLikesBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cUserLikesBucket), testDB.db)
 	_, err = testDB.SyncUser(defaultTestUserData)
 
 	testPost := dbPost{
@@ -96,6 +100,10 @@ func TestDeleteUserPost(t *testing.T) {
 	//Test successful delete scenario
 	createBucketIfNotExistsOrDie([]byte("Users"), testDB.db)
 	createBucketIfNotExistsOrDie([]byte("Posts"), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cPostsByTimeBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cUserPostsBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cLikesBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cUserLikesBucket), testDB.db)
 	_, err := testDB.SyncUser(defaultTestUserData)
 	testPost := dbPost{
 		Text:	"Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim ID est laborum.",
@@ -118,6 +126,10 @@ func TestLikeUserPost(t *testing.T) {
 	//Test successful like and unlike scenario
 	createBucketIfNotExistsOrDie([]byte("Users"), testDB.db)
 	createBucketIfNotExistsOrDie([]byte("Posts"), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cPostsByTimeBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cUserPostsBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cLikesBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cUserLikesBucket), testDB.db)
 	_, err := testDB.SyncUser(defaultTestUserData)
 	testPost := dbPost{
 		Text:	"Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim ID est laborum.",
@@ -132,13 +144,90 @@ func TestLikeUserPost(t *testing.T) {
 
 	actualPost, err := testDB.getUserPost(postID)
 	is.NoErr(err)
-	is.Equal(len(actualPost.Likes), 1)
-	i, _ := utils.FindString(actualPost.Likes, likeOwner)
-	is.True(i >= 0)
+	is.Equal(actualPost.LikesCount, 1)
+	liked, err := testDB.getUserLikes(likeOwner, []int{postID})
+	is.NoErr(err)
+	is.True(liked[postID])
 
 	err = testDB.toggleLikeOnUserPost(defaultUserID, postID, likeOwner)
 	is.NoErr(err)
 	actualPost, err = testDB.getUserPost(postID)
 	is.NoErr(err)
-	is.Equal(len(actualPost.Likes), 0)
-}
\ No newline at end of file
+	is.Equal(actualPost.LikesCount, 0)
+	liked, err = testDB.getUserLikes(likeOwner, []int{postID})
+	is.NoErr(err)
+	is.True(!liked[postID])
+}
+func TestWipeBucket(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	createBucketIfNotExistsOrDie([]byte(cUsersBucket), db)
+	createBucketIfNotExistsOrDie([]byte(cPostsBucket), db)
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(cPostsBucket)).Put([]byte("1"), []byte("post"))
+	})
+	is.NoErr(err)
+
+	is.NoErr(wipeBuckets(db, cPostsBucket))
+	err = db.View(func(tx *bolt.Tx) error {
+		postsBucket := tx.Bucket([]byte(cPostsBucket))
+		is.True(postsBucket != nil)
+		is.Equal(postsBucket.Get([]byte("1")), nil)
+		is.True(tx.Bucket([]byte(cUsersBucket)) != nil)
+		return nil
+	})
+	is.NoErr(err)
+}
+
+func TestWipePostsAndUsers(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	createTestUser(is, db, "alice", "alice.png")
+	createTestUser(is, db, "bob", "bob.png")
+	oldPostID, err := db.saveUserPost([]byte("alice"), "old post")
+	is.NoErr(err)
+	is.NoErr(db.toggleLikeOnUserPost([]byte("alice"), oldPostID, "bob"))
+
+	is.NoErr(wipeBuckets(db.db, cPostsBucket, cPostsByTimeBucket, cUserPostsBucket, cLikesBucket, cUserLikesBucket))
+	// the new post gets the ID of the wiped one and nothing of it
+	postID, err := db.saveUserPost([]byte("bob"), "new post")
+	is.NoErr(err)
+	is.Equal(postID, oldPostID)
+	likers, _, err := db.getPostLikes(postID, "", 10)
+	is.NoErr(err)
+	is.Equal(len(likers), 0)
+	likedPosts, err := db.getLikedPosts("bob")
+	is.NoErr(err)
+	is.Equal(len(likedPosts), 0)
+	posts, _, err := db.getPostsByTime(postsQuery{Limit: 10})
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"new post"})
+	alicePostIDs, err := db.getUserPostIDs("alice")
+	is.NoErr(err)
+	is.Equal(len(alicePostIDs), 0)
+	bobPostIDs, err := db.getUserPostIDs("bob")
+	is.NoErr(err)
+	is.Equal(bobPostIDs, []int{postID})
+
+	// a new account of the same ID starts without the posts and the likes of the wiped one
+	is.NoErr(db.toggleLikeOnUserPost([]byte("bob"), postID, "alice"))
+	is.NoErr(wipeUsers(db.db))
+	is.NoErr(db.db.View(func(tx *bolt.Tx) error {
+		problems, err := fsck(tx, false)
+		is.Equal(len(problems), 0)
+		return err
+	}))
+	createTestUser(is, db, "alice", "alice.png")
+	createTestUser(is, db, "bob", "bob.png")
+	bobPostIDs, err = db.getUserPostIDs("bob")
+	is.NoErr(err)
+	is.Equal(len(bobPostIDs), 0)
+	likedPosts, err = db.getLikedPosts("alice")
+	is.NoErr(err)
+	is.Equal(len(likedPosts), 0)
+	post, err := db.getUserPost(postID)
+	is.NoErr(err)
+	is.Equal(post.LikesCount, 0)
+	is.Equal(len(post.CreatorId), 0)
+}
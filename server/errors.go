@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"tinywebserver/i18n"
+	"tinywebserver/logger"
+	"tinywebserver/utils"
+)
+
+type requestIDKey struct{}
+
+const requestIDHeader = "X-Request-Id"
+
+// withRequestID assigns an ID to every request, so the log lines and the error pages shown to
+// the user can be matched with each other
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := utils.RandString(16)
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		ctx = logger.WithContext(ctx, serverLog.With("request_id", requestID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+type ErrorPage struct {
+	Code      int
+	Title     string
+	Message   string
+	RequestID string
+	Details   string
+}
+
+// renderError logs the error and shows the error page for the status code. The error details are
+// shown to the user only in debug mode
+func (env *environment) renderError(w http.ResponseWriter, r *http.Request, code int, err error) {
+	requestID := requestIDFromContext(r.Context())
+	if err != nil {
+		if code >= http.StatusInternalServerError {
+			requestLog(r).Error("request failed", "method", r.Method, "path", r.URL.Path, "status", code, "err", err)
+		} else {
+			requestLog(r).Info("request rejected", "method", r.Method, "path", r.URL.Path, "status", code, "err", err)
+		}
+	}
+
+	data := env.newViewData(r, nil)
+	page := ErrorPage{
+		Code:      code,
+		Title:     translateOr(data.Locale, fmt.Sprintf("error.%d", code), http.StatusText(code)),
+		Message:   translateOr(data.Locale, fmt.Sprintf("error.message.%d", code), ""),
+		RequestID: requestID,
+	}
+	if len(page.Message) == 0 {
+		page.Message = data.Locale.T("error.message.500")
+	}
+	if env.debug && err != nil {
+		page.Details = err.Error()
+	}
+	data.Page = page
+
+	var buf bytes.Buffer
+	if templates == nil || templates.execute(&buf, "error.html", data) != nil {
+		http.Error(w, page.Title, code)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// translateOr returns the fallback when the catalogs have no message for the key
+func translateOr(locale *i18n.Locale, key string, fallback string) string {
+	if message := locale.T(key); message != key {
+		return message
+	}
+	return fallback
+}
+
+// executeTemplate renders the template into a buffer first, so a failing template results in a
+// proper error page instead of a half written response. While the templates are reloaded, the
+// page shows where the template failed
+func (env *environment) executeTemplate(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	var buf bytes.Buffer
+	err := templates.execute(&buf, name, data)
+	if err != nil {
+		if templates.reload {
+			requestLog(r).Error("template failed", "template", name, "err", err)
+			templates.renderTemplateError(w, err)
+			return
+		}
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (env *environment) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	env.renderError(w, r, http.StatusNotFound, nil)
+}
+
+func (env *environment) methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	env.renderError(w, r, http.StatusMethodNotAllowed, nil)
+}
+
+func (env *environment) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				err := fmt.Errorf("panic: %v\n\n%s", recovered, debug.Stack())
+				env.renderError(w, r, http.StatusInternalServerError, err)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"github.com/matryer/is"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	is := is.New(t)
+
+	for _, debug := range []bool{false, true} {
+		env := environment{debug: debug}
+		rt := newRouter()
+		rt.use(withRequestID, env.recoverPanics)
+		rt.get("/panic", func(w http.ResponseWriter, r *http.Request) {
+			var session map[string]string
+			session["userId"] = "boom"
+		})
+
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+		is.Equal(rec.Code, http.StatusInternalServerError)
+		requestID := rec.Header().Get(requestIDHeader)
+		is.True(len(requestID) > 0)
+		is.True(strings.Contains(rec.Body.String(), requestID))
+		is.Equal(strings.Contains(rec.Body.String(), "assignment to entry in nil map"), debug)
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	is := is.New(t)
+
+	tbl := []struct {
+		code            int
+		err             error
+		debug           bool
+		expectedMessage string
+	}{
+		{http.StatusBadRequest, fmt.Errorf("strconv.Atoi: parsing \"abc\": invalid syntax"), false, "The request couldn't be understood. Please check the address and try again."},
+		{http.StatusForbidden, fmt.Errorf("only the owner of post can delete it"), true, "You don't have permission to access this page."},
+		{http.StatusNotFound, nil, false, "The page you are looking for doesn't exist."},
+		{http.StatusInternalServerError, fmt.Errorf("posts bucket doesn't exist"), false, "Something went wrong on our side. Please try again later."},
+		{http.StatusTeapot, nil, false, "Something went wrong on our side. Please try again later."},
+	}
+	for _, tt := range tbl {
+		env := environment{debug: tt.debug}
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		env.renderError(rec, req, tt.code, tt.err)
+
+		is.Equal(rec.Code, tt.code)
+		is.True(strings.Contains(rec.Body.String(), template.HTMLEscapeString(tt.expectedMessage)))
+		if tt.err != nil {
+			is.Equal(strings.Contains(rec.Body.String(), template.HTMLEscapeString(tt.err.Error())), tt.debug)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"strings"
+	"time"
+	"tinywebserver/utils"
+)
+
+// exportFormat and exportVersion identify the export files, the version grows with every
+// incompatible change of the records
+const (
+	exportFormat  = "tws-export"
+	exportVersion = 1
+)
+
+// The export is NDJSON: the header is followed by one record per line, the type field tells them apart
+const (
+	recordHeader = "header"
+	recordUser   = "user"
+	recordPost   = "post"
+	recordPage   = "page"
+)
+
+type exportHeader struct {
+	Type          string `json:"type"`
+	Format        string `json:"format"`
+	Version       int    `json:"version"`
+	SchemaVersion int    `json:"schema_version"`
+	ExportedAt    string `json:"exported_at"`
+}
+
+type exportUser struct {
+	Type       string    `json:"type"`
+	ID         string    `json:"id"`
+	AvatarURL  string    `json:"avatar_url"`
+	AdminRight UserRight `json:"admin_right"`
+	Locale     string    `json:"locale,omitempty"`
+	// PostIDs are the IDs of the exported posts in the order the user created them
+	PostIDs []int `json:"post_ids"`
+}
+
+type exportPost struct {
+	Type      string   `json:"type"`
+	ID        int      `json:"id"`
+	CreatorID string   `json:"creator_id"`
+	Text      string   `json:"text"`
+	Likes     []string `json:"likes"`
+	CreatedAt string   `json:"created_at"`
+	// RepostID is the ID of the reposted or quoted post, 0 for the plain posts
+	RepostID int `json:"repost_id,omitempty"`
+}
+
+type exportPage struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+// exportStats counts the exported or the imported records
+type exportStats struct {
+	Users, Posts, Pages int
+	// Skipped are the users and the pages which already existed and were kept
+	Skipped int
+}
+
+func (stats exportStats) String() string {
+	return fmt.Sprintf("%v users, %v posts, %v pages, %v skipped", stats.Users, stats.Posts, stats.Pages, stats.Skipped)
+}
+
+// exportData writes all of the data as NDJSON in a single read transaction, so the export is consistent
+func exportData(db *bolt.DB, w io.Writer, now time.Time) (stats exportStats, err error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	err = db.View(func(tx *bolt.Tx) error {
+		schema, err := schemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		header := exportHeader{recordHeader, exportFormat, exportVersion, schema, now.UTC().Format(twsTimeFormat)}
+		if err := encoder.Encode(header); err != nil {
+			return err
+		}
+		err = getBucket(tx, cUsersBucket).ForEach(func(key, value []byte) error {
+			var user dbUserData
+			if err := json.Unmarshal(value, &user); err != nil {
+				return fmt.Errorf("user %s: %w", key, err)
+			}
+			stats.Users++
+			return encoder.Encode(exportUser{recordUser, string(key), user.AvatarUrl, user.AdminRight, user.Locale, userPostIDs(tx, key)})
+		})
+		if err != nil {
+			return err
+		}
+		err = getBucket(tx, cPostsBucket).ForEach(func(key, value []byte) error {
+			var post dbPost
+			if err := json.Unmarshal(value, &post); err != nil {
+				return fmt.Errorf("post %v: %w", utils.Btoi(key), err)
+			}
+			stats.Posts++
+			return encoder.Encode(exportPost{recordPost, utils.Btoi(key), string(post.CreatorId), post.Text,
+				postLikers(tx, utils.Btoi(key)), string(post.CreationDate), post.RepostId})
+		})
+		if err != nil {
+			return err
+		}
+		return getBucket(tx, "PagesData").ForEach(func(key, value []byte) error {
+			stats.Pages++
+			return encoder.Encode(exportPage{recordPage, string(key), string(value)})
+		})
+	})
+	return stats, err
+}
+
+// conflictPolicy decides what happens to the imported users and pages which already exist
+type conflictPolicy string
+
+const (
+	conflictSkip      conflictPolicy = "skip"
+	conflictOverwrite conflictPolicy = "overwrite"
+)
+
+func parseConflictPolicy(value string) (conflictPolicy, error) {
+	switch policy := conflictPolicy(value); policy {
+	case conflictSkip, conflictOverwrite:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, expected skip or overwrite", value)
+}
+
+type exportFile struct {
+	header exportHeader
+	users  []exportUser
+	posts  []exportPost
+	pages  []exportPage
+}
+
+// decodeStrict decodes the record rejecting the unknown fields, they mean the file is of another version
+func decodeStrict(data []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(value)
+}
+
+func readExport(r io.Reader) (*exportFile, error) {
+	file := &exportFile{}
+	decoder := json.NewDecoder(r)
+	for record := 1; ; record++ {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("record %v: %w", record, err)
+		}
+		var kind struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &kind); err != nil {
+			return nil, fmt.Errorf("record %v: %w", record, err)
+		}
+		if record == 1 && kind.Type != recordHeader {
+			return nil, fmt.Errorf("record 1: expected the %v header, got %q", exportFormat, kind.Type)
+		}
+		var err error
+		switch kind.Type {
+		case recordHeader:
+			if record != 1 {
+				return nil, fmt.Errorf("record %v: the header must be the first record", record)
+			}
+			if err = decodeStrict(raw, &file.header); err == nil {
+				if file.header.Format != exportFormat || file.header.Version < 1 || file.header.Version > exportVersion {
+					return nil, fmt.Errorf("unsupported export %v version %v, expected %v version %v at most",
+						file.header.Format, file.header.Version, exportFormat, exportVersion)
+				}
+			}
+		case recordUser:
+			var user exportUser
+			err = decodeStrict(raw, &user)
+			file.users = append(file.users, user)
+		case recordPost:
+			var post exportPost
+			err = decodeStrict(raw, &post)
+			file.posts = append(file.posts, post)
+		case recordPage:
+			var page exportPage
+			err = decodeStrict(raw, &page)
+			file.pages = append(file.pages, page)
+		default:
+			err = fmt.Errorf("unknown record type %q", kind.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %v: %w", record, err)
+		}
+	}
+	if len(file.header.Type) == 0 {
+		return nil, fmt.Errorf("the export is empty")
+	}
+	return file, nil
+}
+
+// validate checks the references between the records, userExists tells whether the user missing
+// in the file is already in the database
+func (file *exportFile) validate(userExists func(id string) bool) error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	users := map[string]exportUser{}
+	for _, user := range file.users {
+		if len(user.ID) == 0 {
+			addProblem("a user has no id")
+		} else if _, ok := users[user.ID]; ok {
+			addProblem("user %v is repeated", user.ID)
+		}
+		users[user.ID] = user
+	}
+	knownUser := func(id string) bool {
+		_, ok := users[id]
+		return ok || userExists(id)
+	}
+
+	posts := map[int]exportPost{}
+	for _, post := range file.posts {
+		if _, ok := posts[post.ID]; ok || post.ID <= 0 {
+			addProblem("post %v: the id is repeated or invalid", post.ID)
+		}
+		posts[post.ID] = post
+	}
+	listed := map[int]bool{}
+	for _, user := range file.users {
+		for _, postID := range user.PostIDs {
+			post, ok := posts[postID]
+			switch {
+			case !ok:
+				addProblem("user %v: post %v doesn't exist", user.ID, postID)
+			case post.CreatorID != user.ID:
+				addProblem("user %v: post %v was created by %v", user.ID, postID, post.CreatorID)
+			case listed[postID]:
+				addProblem("user %v: post %v is listed twice", user.ID, postID)
+			}
+			listed[postID] = true
+		}
+	}
+	for _, post := range file.posts {
+		_, inFile := users[post.CreatorID]
+		switch {
+		case len(post.CreatorID) == 0:
+			// the anonymized posts of the deleted accounts belong to nobody
+		case !knownUser(post.CreatorID):
+			addProblem("post %v: creator %v doesn't exist", post.ID, post.CreatorID)
+		case inFile && !listed[post.ID]:
+			addProblem("post %v: it's missing in the post_ids of %v", post.ID, post.CreatorID)
+		}
+		if post.RepostID != 0 {
+			if _, ok := posts[post.RepostID]; !ok || post.RepostID == post.ID {
+				addProblem("post %v: reposted post %v doesn't exist", post.ID, post.RepostID)
+			}
+		}
+		for _, liker := range post.Likes {
+			if !knownUser(liker) {
+				addProblem("post %v: liked by %v who doesn't exist", post.ID, liker)
+			}
+		}
+		if _, err := time.Parse(twsTimeFormat, post.CreatedAt); err != nil {
+			addProblem("post %v: invalid created_at %q", post.ID, post.CreatedAt)
+		}
+	}
+	pages := map[string]bool{}
+	for _, page := range file.pages {
+		if len(page.Title) == 0 || pages[page.Title] {
+			addProblem("page %q: the title is repeated or empty", page.Title)
+		}
+		pages[page.Title] = true
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid export:\n  %v", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// importData adds the exported data in a single transaction, nothing is written when any of the records
+// is invalid. The posts get new IDs, so the imported data never clashes with the existing posts
+func importData(db *bolt.DB, r io.Reader, policy conflictPolicy) (stats exportStats, err error) {
+	file, err := readExport(r)
+	if err != nil {
+		return stats, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		usersBucket, postsBucket, pagesBucket := getBucket(tx, cUsersBucket), getBucket(tx, cPostsBucket), getBucket(tx, "PagesData")
+		if usersBucket == nil || postsBucket == nil || pagesBucket == nil {
+			return errors.New("the database isn't initialized")
+		}
+		err := file.validate(func(id string) bool { return usersBucket.Get([]byte(id)) != nil })
+		if err != nil {
+			return err
+		}
+
+		// the IDs are assigned first, the reposts may come before the reposted posts
+		newIDs := map[int]int{}
+		for _, post := range file.posts {
+			id, err := postsBucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			newIDs[post.ID] = int(id)
+		}
+		for _, post := range file.posts {
+			stored := dbPost{
+				Text:         post.Text,
+				CreationDate: []byte(post.CreatedAt),
+				CreatorId:    []byte(post.CreatorID),
+				RepostId:     newIDs[post.RepostID],
+			}
+			if len(post.Likes) > 0 {
+				for _, liker := range post.Likes {
+					if err := addLike(tx, newIDs[post.ID], liker); err != nil {
+						return err
+					}
+				}
+				stored.LikesCount = len(postLikers(tx, newIDs[post.ID]))
+			}
+			buf, err := json.Marshal(stored)
+			if err != nil {
+				return err
+			}
+			if err := postsBucket.Put(utils.Itob(newIDs[post.ID]), buf); err != nil {
+				return err
+			}
+			if err := indexPost(tx, newIDs[post.ID], stored); err != nil {
+				return err
+			}
+			stats.Posts++
+		}
+
+		fileUsers := map[string]bool{}
+		for _, user := range file.users {
+			fileUsers[user.ID] = true
+			stored := dbUserData{}
+			existing := usersBucket.Get([]byte(user.ID))
+			if existing != nil {
+				if err := json.Unmarshal(existing, &stored); err != nil {
+					return fmt.Errorf("user %v: %w", user.ID, err)
+				}
+			}
+			if existing == nil || policy == conflictOverwrite {
+				stored.AvatarUrl, stored.AdminRight, stored.Locale = user.AvatarURL, user.AdminRight, user.Locale
+				stats.Users++
+			} else {
+				stats.Skipped++
+			}
+			buf, err := json.Marshal(stored)
+			if err != nil {
+				return err
+			}
+			if err := usersBucket.Put([]byte(user.ID), buf); err != nil {
+				return err
+			}
+			// the existing posts of the user stay, the imported ones are added with their new IDs
+			for _, postID := range user.PostIDs {
+				if err := appendPostToUser(tx, []byte(user.ID), newIDs[postID]); err != nil {
+					return err
+				}
+			}
+		}
+		// the posts of the users who are only in the database
+		for _, post := range file.posts {
+			if fileUsers[post.CreatorID] || len(post.CreatorID) == 0 {
+				continue
+			}
+			if err := appendPostToUser(tx, []byte(post.CreatorID), newIDs[post.ID]); err != nil {
+				return err
+			}
+		}
+
+		for _, page := range file.pages {
+			if pagesBucket.Get([]byte(page.Title)) != nil && policy == conflictSkip {
+				stats.Skipped++
+				continue
+			}
+			if err := pagesBucket.Put([]byte(page.Title), []byte(page.Body)); err != nil {
+				return err
+			}
+			stats.Pages++
+		}
+		return nil
+	})
+	if err != nil {
+		return exportStats{}, err
+	}
+	return stats, nil
+}
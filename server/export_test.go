@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/matryer/is"
+	"strings"
+	"testing"
+	"time"
+	"tinywebserver/utils"
+)
+
+func migratedTestDB(is *is.I, t *testing.T) *twsDB {
+	db := generateTestDB(is, t)
+	_, err := migrate(db, migrations, false)
+	is.NoErr(err)
+	return &twsDB{db: db}
+}
+
+func createTestUser(is *is.I, db *twsDB, id string, avatar string) {
+	_, err := db.SyncUser(TwsUserData{Id: id, AvatarUrl: avatar})
+	is.NoErr(err)
+}
+
+func TestExportImport(t *testing.T) {
+	is := is.New(t)
+	source := migratedTestDB(is, t)
+	createTestUser(is, source, "alice", "alice.png")
+	createTestUser(is, source, "bob", "bob.png")
+	postID, err := source.saveUserPost([]byte("alice"), "hello")
+	is.NoErr(err)
+	quoteID, err := source.repostUserPost(utils.Itob(postID), []byte("bob"), "quoting <alice>")
+	is.NoErr(err)
+	is.NoErr(source.toggleLikeOnUserPost([]byte("alice"), postID, "bob"))
+	is.NoErr(source.SavePage("index", []byte("main page")))
+
+	var export bytes.Buffer
+	stats, err := exportData(source.db, &export, time.Date(2022, time.March, 8, 10, 4, 0, 0, time.UTC))
+	is.NoErr(err)
+	is.Equal(stats, exportStats{Users: 2, Posts: 2, Pages: 1})
+	lines := strings.Split(strings.TrimSpace(export.String()), "\n")
+	is.Equal(len(lines), 6)
+	schema := migrations[len(migrations)-1].version
+	is.Equal(lines[0], fmt.Sprintf(`{"type":"header","format":"tws-export","version":1,"schema_version":%v,"exported_at":"2022-03-08T10:04:00.000Z"}`, schema))
+	is.True(strings.Contains(export.String(), `"text":"quoting <alice>"`))
+
+	// the target has posts of its own, so the imported posts get new IDs
+	target := migratedTestDB(is, t)
+	createTestUser(is, target, "carol", "carol.png")
+	_, err = target.saveUserPost([]byte("carol"), "first")
+	is.NoErr(err)
+	stats, err = importData(target.db, bytes.NewReader(export.Bytes()), conflictSkip)
+	is.NoErr(err)
+	is.Equal(stats, exportStats{Users: 2, Posts: 2, Pages: 1})
+
+	alicePostIDs, err := target.getUserPostIDs("alice")
+	is.NoErr(err)
+	is.Equal(alicePostIDs, []int{postID + 1})
+	bobPostIDs, err := target.getUserPostIDs("bob")
+	is.NoErr(err)
+	is.Equal(bobPostIDs, []int{quoteID + 1})
+	post, err := target.getUserPost(postID + 1)
+	is.NoErr(err)
+	is.Equal(post.Text, "hello")
+	is.Equal(post.LikesCount, 1)
+	likers, _, err := target.getPostLikes(postID+1, "", 10)
+	is.NoErr(err)
+	is.Equal(likers, []string{"bob"})
+	original, err := source.getUserPost(postID)
+	is.NoErr(err)
+	is.Equal(post.CreationDate, original.CreationDate)
+	quote, err := target.getUserPost(quoteID + 1)
+	is.NoErr(err)
+	is.Equal(quote.RepostId, postID+1)
+	page, err := target.GetPage("index")
+	is.NoErr(err)
+	is.Equal(string(page), "main page")
+}
+
+func TestImportConflicts(t *testing.T) {
+	is := is.New(t)
+	export := `{"type":"header","format":"tws-export","version":1,"schema_version":1,"exported_at":"2022-03-08T10:04:00.000Z"}
+{"type":"user","id":"alice","avatar_url":"new.png","admin_right":0,"post_ids":[7]}
+{"type":"post","id":7,"creator_id":"alice","text":"imported","likes":null,"created_at":"2022-03-08T10:04:00.000Z"}
+{"type":"page","title":"index","body":"imported page"}
+`
+	tests := []struct {
+		policy   conflictPolicy
+		avatar   string
+		page     string
+		expected exportStats
+	}{
+		{conflictSkip, "old.png", "old page", exportStats{Posts: 1, Skipped: 2}},
+		{conflictOverwrite, "new.png", "imported page", exportStats{Users: 1, Posts: 1, Pages: 1}},
+	}
+	for _, tt := range tests {
+		db := migratedTestDB(is, t)
+		createTestUser(is, db, "alice", "old.png")
+		oldPostID, err := db.saveUserPost([]byte("alice"), "old")
+		is.NoErr(err)
+		is.NoErr(db.SavePage("index", []byte("old page")))
+
+		stats, err := importData(db.db, strings.NewReader(export), tt.policy)
+		is.NoErr(err)
+		is.Equal(stats, tt.expected)
+		alice, err := db.getUser("alice")
+		is.NoErr(err)
+		is.Equal(alice.AvatarUrl, tt.avatar)
+		// the existing posts are kept with either of the policies
+		alicePostIDs, err := db.getUserPostIDs("alice")
+		is.NoErr(err)
+		is.Equal(alicePostIDs, []int{oldPostID, oldPostID + 1})
+		page, err := db.GetPage("index")
+		is.NoErr(err)
+		is.Equal(string(page), tt.page)
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	header := `{"type":"header","format":"tws-export","version":1,"schema_version":1,"exported_at":""}` + "\n"
+	user := `{"type":"user","id":"alice","avatar_url":"","admin_right":0,"post_ids":[1]}` + "\n"
+	post := func(fields string) string {
+		return `{"type":"post","id":1,"creator_id":"alice","text":"hi","likes":null,"created_at":"2022-03-08T10:04:00.000Z"` + fields + "}\n"
+	}
+	tests := []struct {
+		name     string
+		export   string
+		expected string
+	}{
+		{"empty", "", "the export is empty"},
+		{"no header", user, "expected the tws-export header"},
+		{"newer version", strings.Replace(header, `"version":1`, `"version":2`, 1), "unsupported export tws-export version 2"},
+		{"unknown field", header + strings.Replace(user, `"admin_right"`, `"admin":1,"admin_right"`, 1), `unknown field "admin"`},
+		{"unknown record", header + `{"type":"comment"}`, `unknown record type "comment"`},
+		{"missing creator", header + post(""), "creator alice doesn't exist"},
+		{"missing reposted post", header + user + post(`,"repost_id":5`), "reposted post 5 doesn't exist"},
+		{"missing liker", header + user + strings.Replace(post(""), "null", `["bob"]`, 1), "liked by bob who doesn't exist"},
+		{"unlisted post", header + strings.Replace(user, "[1]", "[]", 1) + post(""), "missing in the post_ids of alice"},
+		{"missing listed post", header + user, "post 1 doesn't exist"},
+		{"invalid date", header + user + strings.Replace(post(""), "2022-03-08T10:04:00.000Z", "yesterday", 1), `invalid created_at "yesterday"`},
+	}
+	for _, tt := range tests {
+		is := is.New(t)
+		db := migratedTestDB(is, t)
+		_, err := importData(db.db, strings.NewReader(tt.export), conflictSkip)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%v: expected an error with %q, got %v", tt.name, tt.expected, err)
+		}
+		// nothing is written
+		is.NoErr(db.db.View(func(tx *bolt.Tx) error {
+			is.Equal(getBucket(tx, cPostsBucket).Sequence(), uint64(0))
+			is.Equal(getBucket(tx, cUsersBucket).Stats().KeyN, 0)
+			return nil
+		}))
+	}
+}
@@ -0,0 +1,428 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"tinywebserver/utils"
+)
+
+// fsckProblem is an inconsistency of the stored data, Repair tells what the repair does with it
+type fsckProblem struct {
+	Bucket string
+	Key    string
+	Issue  string
+	Repair string
+}
+
+func (problem fsckProblem) String() string {
+	return fmt.Sprintf("%v/%v: %v; %v", problem.Bucket, problem.Key, problem.Issue, problem.Repair)
+}
+
+// fsck checks the users and the posts against each other. With repair, the problems are fixed in
+// the same transaction, so the caller runs it in an update one
+func fsck(tx *bolt.Tx, repair bool) ([]fsckProblem, error) {
+	usersBucket, postsBucket := getBucket(tx, cUsersBucket), getBucket(tx, cPostsBucket)
+	if usersBucket == nil || postsBucket == nil {
+		return nil, errors.New("the database isn't initialized")
+	}
+	var problems []fsckProblem
+	report := func(bucket string, key string, repair string, issue string, args ...interface{}) {
+		problems = append(problems, fsckProblem{Bucket: bucket, Key: key, Issue: fmt.Sprintf(issue, args...), Repair: repair})
+	}
+
+	// the records which can't be decoded are deleted, the others then look as if they never existed
+	users := map[string]dbUserData{}
+	var malformedUsers [][]byte
+	err := usersBucket.ForEach(func(k, v []byte) error {
+		user := dbUserData{}
+		if err := json.Unmarshal(v, &user); err != nil {
+			report(cUsersBucket, string(k), "the user is deleted", "malformed JSON: %v", err)
+			malformedUsers = append(malformedUsers, append([]byte{}, k...))
+			return nil
+		}
+		users[string(k)] = user
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	posts := map[int]dbPost{}
+	var malformedPosts [][]byte
+	err = postsBucket.ForEach(func(k, v []byte) error {
+		post := dbPost{}
+		err := json.Unmarshal(v, &post)
+		if err == nil && len(k) != 8 {
+			err = fmt.Errorf("the key isn't a post ID")
+		} else if err == nil {
+			_, err = postCreationTime(post)
+		}
+		if err != nil {
+			report(cPostsBucket, fmt.Sprintf("%x", k), "the post is deleted", "malformed JSON: %v", err)
+			malformedPosts = append(malformedPosts, append([]byte{}, k...))
+			return nil
+		}
+		posts[utils.Btoi(k)] = post
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the databases which aren't migrated yet have neither the buckets of the user posts, the time
+	// index nor the likes to check
+	userPostsBucket := getBucket(tx, cUserPostsBucket)
+	listed := map[string]map[int]bool{}
+	var staleUserPosts []fsckNestedKey
+	var staleUserBuckets [][]byte
+	if userPostsBucket != nil {
+		err = userPostsBucket.ForEach(func(k, v []byte) error {
+			userID := string(k)
+			if _, ok := users[userID]; !ok || v != nil {
+				report(cUserPostsBucket, userID, "the bucket is removed", "posts of %v who doesn't exist", userID)
+				staleUserBuckets = append(staleUserBuckets, append([]byte{}, k...))
+				return nil
+			}
+			listed[userID] = map[int]bool{}
+			return userPostsBucket.Bucket(k).ForEach(func(postKey, _ []byte) error {
+				if len(postKey) != 8 {
+					report(cUserPostsBucket, userID, "the ID is removed", "key %x isn't a post ID", postKey)
+					staleUserPosts = append(staleUserPosts, fsckNestedKey{append([]byte{}, k...), append([]byte{}, postKey...)})
+					return nil
+				}
+				postID := utils.Btoi(postKey)
+				post, ok := posts[postID]
+				switch {
+				case !ok:
+					report(cUserPostsBucket, userID, "the ID is removed", "post %v doesn't exist", postID)
+				case string(post.CreatorId) != userID:
+					report(cUserPostsBucket, userID, "the ID is removed", "post %v was created by %q", postID, post.CreatorId)
+				default:
+					listed[userID][postID] = true
+					return nil
+				}
+				staleUserPosts = append(staleUserPosts, fsckNestedKey{append([]byte{}, k...), append([]byte{}, postKey...)})
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	indexBucket := getBucket(tx, cPostsByTimeBucket)
+	indexed := map[int]bool{}
+	var staleIndexKeys [][]byte
+	if indexBucket != nil {
+		err = indexBucket.ForEach(func(k, v []byte) error {
+			if len(k) != 16 {
+				report(cPostsByTimeBucket, fmt.Sprintf("%x", k), "the entry is removed", "the key isn't a time and a post ID")
+				staleIndexKeys = append(staleIndexKeys, append([]byte{}, k...))
+				return nil
+			}
+			postID := utils.Btoi(k[8:])
+			post, ok := posts[postID]
+			if !ok {
+				report(cPostsByTimeBucket, strconv.Itoa(postID), "the entry is removed", "indexed post %v doesn't exist", postID)
+				staleIndexKeys = append(staleIndexKeys, append([]byte{}, k...))
+				return nil
+			}
+			created, _ := postCreationTime(post)
+			if !bytes.Equal(k, postTimeKey(created, postID)) {
+				report(cPostsByTimeBucket, strconv.Itoa(postID), "the entry is removed", "post %v is indexed at the wrong time", postID)
+				staleIndexKeys = append(staleIndexKeys, append([]byte{}, k...))
+				return nil
+			}
+			indexed[postID] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	likesBucket := getBucket(tx, cLikesBucket)
+	likesCounts := map[int]int{}
+	var likes []fsckNestedKey
+	var staleLikeBuckets [][]byte
+	var staleLikes []fsckNestedKey
+	if likesBucket != nil {
+		err = likesBucket.ForEach(func(k, v []byte) error {
+			if len(k) != 8 || v != nil {
+				report(cLikesBucket, fmt.Sprintf("%x", k), "the likes are removed", "the key isn't a post ID")
+				staleLikeBuckets = append(staleLikeBuckets, append([]byte{}, k...))
+				return nil
+			}
+			postID := utils.Btoi(k)
+			if _, ok := posts[postID]; !ok {
+				report(cLikesBucket, strconv.Itoa(postID), "the likes are removed", "liked post %v doesn't exist", postID)
+				staleLikeBuckets = append(staleLikeBuckets, append([]byte{}, k...))
+				return nil
+			}
+			return likesBucket.Bucket(k).ForEach(func(liker, _ []byte) error {
+				if _, ok := users[string(liker)]; !ok {
+					report(cLikesBucket, strconv.Itoa(postID), "the like is removed", "liked by %s who doesn't exist", liker)
+					staleLikes = append(staleLikes, fsckNestedKey{append([]byte{}, k...), append([]byte{}, liker...)})
+					return nil
+				}
+				likesCounts[postID]++
+				likes = append(likes, fsckNestedKey{append([]byte{}, k...), append([]byte{}, liker...)})
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the UserLikes buckets must list exactly the likes found above
+	userLikesBucket := getBucket(tx, cUserLikesBucket)
+	liked := map[string]map[int]bool{}
+	for _, like := range likes {
+		if liked[string(like.key)] == nil {
+			liked[string(like.key)] = map[int]bool{}
+		}
+		liked[string(like.key)][utils.Btoi(like.parent)] = true
+	}
+	var staleUserLikeBuckets [][]byte
+	var staleUserLikes, missingUserLikes []fsckNestedKey
+	if likesBucket != nil && userLikesBucket != nil {
+		err = userLikesBucket.ForEach(func(k, v []byte) error {
+			userID := string(k)
+			if _, ok := users[userID]; !ok || v != nil {
+				report(cUserLikesBucket, userID, "the bucket is removed", "likes of %v who doesn't exist", userID)
+				staleUserLikeBuckets = append(staleUserLikeBuckets, append([]byte{}, k...))
+				return nil
+			}
+			return userLikesBucket.Bucket(k).ForEach(func(postKey, _ []byte) error {
+				switch {
+				case len(postKey) != 8:
+					report(cUserLikesBucket, userID, "the ID is removed", "key %x isn't a post ID", postKey)
+				case !liked[userID][utils.Btoi(postKey)]:
+					report(cUserLikesBucket, userID, "the ID is removed", "post %v isn't liked by %v", utils.Btoi(postKey), userID)
+				default:
+					delete(liked[userID], utils.Btoi(postKey))
+					return nil
+				}
+				staleUserLikes = append(staleUserLikes, fsckNestedKey{append([]byte{}, k...), append([]byte{}, postKey...)})
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, like := range likes {
+			if liked[string(like.key)][utils.Btoi(like.parent)] {
+				report(cUserLikesBucket, string(like.key), "the ID is added", "like of post %v is missing", utils.Btoi(like.parent))
+				missingUserLikes = append(missingUserLikes, like)
+			}
+		}
+	}
+
+	changedPosts := map[int]bool{}
+	var unlisted []int
+	missingReposted := map[int]bool{}
+	for _, postID := range sortedPostIDs(posts) {
+		post := posts[postID]
+		key := strconv.Itoa(postID)
+		creatorID := string(post.CreatorId)
+		if indexBucket != nil && !indexed[postID] {
+			report(cPostsBucket, key, "the post is indexed", "missing in the %v index", cPostsByTimeBucket)
+		}
+		if _, ok := users[creatorID]; len(creatorID) > 0 && !ok {
+			report(cPostsBucket, key, "the post is anonymized", "creator %v doesn't exist", creatorID)
+			post.CreatorId = nil
+			changedPosts[postID] = true
+		} else if len(creatorID) > 0 && userPostsBucket != nil && !listed[creatorID][postID] {
+			report(cPostsBucket, key, "the ID is added to the user", "missing in the posts of %v", creatorID)
+			unlisted = append(unlisted, postID)
+		}
+
+		if likesBucket != nil && post.LikesCount != likesCounts[postID] {
+			report(cPostsBucket, key, "the count is corrected", "%v likes are counted, the post has %v", post.LikesCount, likesCounts[postID])
+			post.LikesCount = likesCounts[postID]
+			changedPosts[postID] = true
+		}
+
+		if _, ok := posts[post.RepostId]; post.RepostId > 0 && !ok {
+			if len(post.Text) == 0 {
+				report(cPostsBucket, key, "the repost is deleted", "reposted post %v doesn't exist", post.RepostId)
+			} else {
+				report(cPostsBucket, key, "the quote becomes a regular post", "quoted post %v doesn't exist", post.RepostId)
+			}
+			missingReposted[post.RepostId] = true
+		}
+		posts[postID] = post
+	}
+	if !repair || len(problems) == 0 {
+		return problems, nil
+	}
+
+	for _, key := range malformedUsers {
+		if err := usersBucket.Delete(key); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range malformedPosts {
+		if err := postsBucket.Delete(key); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range staleIndexKeys {
+		if err := indexBucket.Delete(key); err != nil {
+			return nil, err
+		}
+	}
+	for postID, post := range posts {
+		if indexBucket != nil && !indexed[postID] {
+			if err := indexPost(tx, postID, post); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, key := range staleUserBuckets {
+		// the values which aren't buckets are removed as the plain keys
+		remove := userPostsBucket.Delete
+		if userPostsBucket.Bucket(key) != nil {
+			remove = userPostsBucket.DeleteBucket
+		}
+		if err := remove(key); err != nil {
+			return nil, err
+		}
+	}
+	for _, userPost := range staleUserPosts {
+		if err := userPostsBucket.Bucket(userPost.parent).Delete(userPost.key); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range staleLikeBuckets {
+		remove := likesBucket.Delete
+		if likesBucket.Bucket(key) != nil {
+			remove = likesBucket.DeleteBucket
+		}
+		if err := remove(key); err != nil {
+			return nil, err
+		}
+	}
+	for _, like := range staleLikes {
+		if err := likesBucket.Bucket(like.parent).Delete(like.key); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range staleUserLikeBuckets {
+		remove := userLikesBucket.Delete
+		if userLikesBucket.Bucket(key) != nil {
+			remove = userLikesBucket.DeleteBucket
+		}
+		if err := remove(key); err != nil {
+			return nil, err
+		}
+	}
+	for _, like := range staleUserLikes {
+		if err := userLikesBucket.Bucket(like.parent).Delete(like.key); err != nil {
+			return nil, err
+		}
+	}
+	for _, like := range missingUserLikes {
+		userLikes, err := userLikesBucket.CreateBucketIfNotExists(like.key)
+		if err != nil {
+			return nil, err
+		}
+		if err := userLikes.Put(like.parent, []byte{}); err != nil {
+			return nil, err
+		}
+	}
+	for _, postID := range unlisted {
+		if err := appendPostToUser(tx, posts[postID].CreatorId, postID); err != nil {
+			return nil, err
+		}
+	}
+	for postID := range changedPosts {
+		buf, err := json.Marshal(posts[postID])
+		if err != nil {
+			return nil, err
+		}
+		if err := postsBucket.Put(utils.Itob(postID), buf); err != nil {
+			return nil, err
+		}
+	}
+	// the reposts and the quotes are cleaned up the same way as when the posts of an account are deleted
+	if err := detachRemovedPosts(tx, missingReposted, "", &deletionStats{}); err != nil {
+		return nil, err
+	}
+
+	remaining, err := fsck(tx, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("%v problems remain after the repair, the first one is %v", len(remaining), remaining[0])
+	}
+	return problems, nil
+}
+
+// fsckNestedKey is the key of a nested bucket, e.g. a post in the bucket of the user's posts
+type fsckNestedKey struct {
+	parent []byte
+	key    []byte
+}
+
+func sortedPostIDs(posts map[int]dbPost) []int {
+	ids := make([]int, 0, len(posts))
+	for id := range posts {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// fsckCommand checks the database of the stopped server. With repair, the problems found are
+// fixed in a single transaction once the user confirms it
+func fsckCommand(path string, repair bool, input io.Reader, output io.Writer) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := openLocked(path, &bolt.Options{ReadOnly: !repair, Timeout: lockTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var problems []fsckProblem
+	err = db.View(func(tx *bolt.Tx) error {
+		problems, err = fsck(tx, false)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(output, problem)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintln(output, "No problems found")
+		return nil
+	}
+	if !repair {
+		return fmt.Errorf("found %v problems, run with -fsckRepair to repair them", len(problems))
+	}
+	if !confirm(input, fmt.Sprintf("Are you sure you want to REPAIR %v problems? Back the database up first with -backup. (Yes or y)", len(problems))) {
+		fmt.Fprintln(output, "Please type <yes> or <y> if you want to repair the database!")
+		return nil
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		problems, err = fsck(tx, true)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("repair failed, nothing was changed: %w", err)
+	}
+	fmt.Fprintf(output, "Repaired %v problems\n", len(problems))
+	return nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/matryer/is"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"tinywebserver/utils"
+)
+
+func putTestRecord(is *is.I, db *twsDB, bucket string, key []byte, value interface{}) {
+	buf, ok := value.([]byte)
+	if !ok {
+		var err error
+		buf, err = json.Marshal(value)
+		is.NoErr(err)
+	}
+	is.NoErr(db.db.Update(func(tx *bolt.Tx) error {
+		return getBucket(tx, bucket).Put(key, buf)
+	}))
+}
+
+func putTestNested(is *is.I, db *twsDB, bucketName string, nested []byte, keys ...string) {
+	is.NoErr(db.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := getBucket(tx, bucketName).CreateBucketIfNotExists(nested)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := bucket.Put([]byte(key), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+func putTestUserPosts(is *is.I, db *twsDB, userID string, postIDs ...int) {
+	var keys []string
+	for _, postID := range postIDs {
+		keys = append(keys, string(utils.Itob(postID)))
+	}
+	putTestNested(is, db, cUserPostsBucket, []byte(userID), keys...)
+}
+
+func TestFsck(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	date := []byte("2022-03-08T10:04:00.000Z")
+	putTestRecord(is, db, cUsersBucket, []byte("alice"), dbUserData{})
+	putTestRecord(is, db, cUsersBucket, []byte("bob"), dbUserData{})
+	putTestUserPosts(is, db, "alice", 1, 9, 4)
+	putTestUserPosts(is, db, "bob", 2, 3)
+	putTestUserPosts(is, db, "ghost", 5)
+	putTestNested(is, db, cLikesBucket, utils.Itob(1), "alice", "bob", "ghost")
+	putTestNested(is, db, cLikesBucket, utils.Itob(9), "bob")
+	putTestNested(is, db, cUserLikesBucket, []byte("bob"), string(utils.Itob(1)), string(utils.Itob(7)))
+	putTestNested(is, db, cUserLikesBucket, []byte("ghost"), string(utils.Itob(1)))
+	putTestRecord(is, db, cUsersBucket, []byte("broken"), []byte("{"))
+	putTestRecord(is, db, cPostsBucket, utils.Itob(1), dbPost{Text: "hi", CreationDate: date, CreatorId: []byte("alice"), LikesCount: 3})
+	putTestRecord(is, db, cPostsBucket, utils.Itob(2), dbPost{CreationDate: date, CreatorId: []byte("bob"), RepostId: 7})
+	putTestRecord(is, db, cPostsBucket, utils.Itob(3), dbPost{Text: "quote", CreationDate: date, CreatorId: []byte("bob"), RepostId: 8})
+	putTestRecord(is, db, cPostsBucket, utils.Itob(4), dbPost{Text: "unlisted", CreationDate: date, CreatorId: []byte("bob")})
+	putTestRecord(is, db, cPostsBucket, utils.Itob(5), dbPost{Text: "orphan", CreationDate: date, CreatorId: []byte("ghost")})
+	putTestRecord(is, db, cPostsBucket, utils.Itob(8), []byte("not json"))
+	created, err := time.Parse(twsTimeFormat, string(date))
+	is.NoErr(err)
+	for _, postID := range []int{1, 2, 4, 6} {
+		putTestRecord(is, db, cPostsByTimeBucket, postTimeKey(created, postID), []byte{})
+	}
+	putTestRecord(is, db, cPostsByTimeBucket, postTimeKey(created.Add(time.Hour), 3), []byte{})
+
+	var problems []fsckProblem
+	is.NoErr(db.db.View(func(tx *bolt.Tx) error {
+		var err error
+		problems, err = fsck(tx, false)
+		return err
+	}))
+	var issues []string
+	for _, problem := range problems {
+		issues = append(issues, problem.Bucket+"/"+problem.Key+": "+problem.Issue)
+	}
+	expected := []string{
+		`Users/broken: malformed JSON: unexpected end of JSON input`,
+		`Posts/0000000000000008: malformed JSON: invalid character 'o' in literal null (expecting 'u')`,
+		`UserPosts/alice: post 4 was created by "bob"`,
+		`UserPosts/alice: post 9 doesn't exist`,
+		`UserPosts/ghost: posts of ghost who doesn't exist`,
+		`PostsByTime/6: indexed post 6 doesn't exist`,
+		`PostsByTime/3: post 3 is indexed at the wrong time`,
+		`Likes/1: liked by ghost who doesn't exist`,
+		`Likes/9: liked post 9 doesn't exist`,
+		`UserLikes/bob: post 7 isn't liked by bob`,
+		`UserLikes/ghost: likes of ghost who doesn't exist`,
+		`UserLikes/alice: like of post 1 is missing`,
+		`Posts/1: 3 likes are counted, the post has 2`,
+		`Posts/2: reposted post 7 doesn't exist`,
+		`Posts/3: missing in the PostsByTime index`,
+		`Posts/3: quoted post 8 doesn't exist`,
+		`Posts/4: missing in the posts of bob`,
+		`Posts/5: missing in the PostsByTime index`,
+		`Posts/5: creator ghost doesn't exist`,
+	}
+	is.Equal(strings.Join(issues, "\n"), strings.Join(expected, "\n"))
+
+	is.NoErr(db.db.Update(func(tx *bolt.Tx) error {
+		repaired, err := fsck(tx, true)
+		is.Equal(len(repaired), len(expected))
+		return err
+	}))
+	posts, _, err := db.getPostsByTime(postsQuery{Limit: 10})
+	is.NoErr(err)
+	is.Equal(len(posts), 4)
+	alicePostIDs, err := db.getUserPostIDs("alice")
+	is.NoErr(err)
+	is.Equal(alicePostIDs, []int{1})
+	bobPostIDs, err := db.getUserPostIDs("bob")
+	is.NoErr(err)
+	is.Equal(bobPostIDs, []int{3, 4})
+	_, err = db.getUser("broken")
+	is.True(err != nil)
+	post, err := db.getUserPost(1)
+	is.NoErr(err)
+	is.Equal(post.LikesCount, 2)
+	likers, _, err := db.getPostLikes(1, "", 10)
+	is.NoErr(err)
+	is.Equal(likers, []string{"alice", "bob"})
+	likedPosts, err := db.getLikedPosts("alice")
+	is.NoErr(err)
+	is.Equal(postTexts(likedPosts), []string{"hi"})
+	_, err = db.getUserPost(2)
+	is.True(err != nil)
+	quote, err := db.getUserPost(3)
+	is.NoErr(err)
+	is.Equal(quote.RepostId, 0)
+	orphan, err := db.getUserPost(5)
+	is.NoErr(err)
+	is.Equal(len(orphan.CreatorId), 0)
+}
+
+func TestFsckCommand(t *testing.T) {
+	is := is.New(t)
+	path := filepath.Join(t.TempDir(), "tws.db")
+	is.NoErr(InitDB(path))
+	var output bytes.Buffer
+	is.NoErr(fsckCommand(path, false, strings.NewReader(""), &output))
+	is.Equal(output.String(), "No problems found\n")
+
+	db, err := bolt.Open(path, 0600, nil)
+	is.NoErr(err)
+	putTestRecord(is, &twsDB{db: db}, cUsersBucket, []byte("alice"), dbUserData{})
+	putTestUserPosts(is, &twsDB{db: db}, "alice", 1)
+	is.NoErr(db.Close())
+
+	output.Reset()
+	err = fsckCommand(path, false, strings.NewReader(""), &output)
+	is.True(err != nil && strings.Contains(err.Error(), "found 1 problems"))
+	is.Equal(output.String(), "UserPosts/alice: post 1 doesn't exist; the ID is removed\n")
+
+	// nothing is repaired without the confirmation
+	is.NoErr(fsckCommand(path, true, strings.NewReader("no\n"), &output))
+	is.True(fsckCommand(path, false, strings.NewReader(""), &output) != nil)
+	output.Reset()
+	is.NoErr(fsckCommand(path, true, strings.NewReader("y\n"), &output))
+	is.True(strings.HasSuffix(output.String(), "Repaired 1 problems\n"))
+	is.NoErr(fsckCommand(path, false, strings.NewReader(""), &output))
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"tinywebserver/buildinfo"
+)
+
+// healthState is shared by the handlers and the shutdown procedure
+type healthState struct {
+	shuttingDown int32
+}
+
+// beginShutdown makes the readiness check fail, so no new traffic is routed to the instance
+func (env *environment) beginShutdown() {
+	atomic.StoreInt32(&env.health.shuttingDown, 1)
+}
+
+func (env *environment) isShuttingDown() bool {
+	return atomic.LoadInt32(&env.health.shuttingDown) == 1
+}
+
+type readinessCheck struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type readinessReport struct {
+	Ready  bool             `json:"ready"`
+	Checks []readinessCheck `json:"checks"`
+}
+
+func (env *environment) readiness() readinessReport {
+	report := readinessReport{Ready: true}
+	addCheck := func(name string, err error) {
+		check := readinessCheck{Name: name, Ok: err == nil}
+		if err != nil {
+			check.Error = err.Error()
+			report.Ready = false
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	var dbErr error
+	if env.db == nil {
+		dbErr = fmt.Errorf("database isn't opened")
+	} else {
+		dbErr = env.db.checkHealth()
+	}
+	addCheck("database", dbErr)
+
+	var templatesErr error
+	if templates == nil {
+		templatesErr = fmt.Errorf("templates aren't parsed")
+	} else if _, err := templates.get(); err != nil {
+		templatesErr = err
+	}
+	addCheck("templates", templatesErr)
+
+	var oauthErr error
+	if env.oauth == nil {
+		oauthErr = fmt.Errorf("oauth config isn't loaded")
+	}
+	addCheck("oauth", oauthErr)
+
+	var shutdownErr error
+	if env.isShuttingDown() {
+		shutdownErr = fmt.Errorf("server is shutting down")
+	}
+	addCheck("shutdown", shutdownErr)
+	return report
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
+
+// healthzHandler reports that the process is alive and serving requests
+func (env *environment) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether the instance is able to handle user traffic
+func (env *environment) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := env.readiness()
+	code := http.StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, report)
+}
+
+func (env *environment) versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildinfo.Get())
+}
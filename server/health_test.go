@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tinywebserver/buildinfo"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	is := is.New(t)
+	env := environment{db: &stubDB{}, oauth: &stubOauth{}}
+	mux := env.routes()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.Equal(rec.Code, http.StatusOK)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	is.Equal(rec.Code, http.StatusOK)
+
+	env.beginShutdown()
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	is.Equal(rec.Code, http.StatusServiceUnavailable)
+	report := readinessReport{}
+	is.NoErr(json.Unmarshal(rec.Body.Bytes(), &report))
+	is.True(!report.Ready)
+
+	// healthz keeps reporting alive while the server drains connections
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.Equal(rec.Code, http.StatusOK)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	info := buildinfo.Info{}
+	is.NoErr(json.Unmarshal(rec.Body.Bytes(), &info))
+	is.Equal(info.Commit, buildinfo.Commit)
+}
+
+func TestReadinessChecksDatabase(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	env := environment{db: &twsDB{db: db}, oauth: &stubOauth{}}
+
+	report := env.readiness()
+	is.True(!report.Ready)
+	is.Equal(report.Checks[0].Name, "database")
+	is.True(!report.Checks[0].Ok)
+
+	createBucketIfNotExistsOrDie([]byte("PagesData"), db)
+	createBucketIfNotExistsOrDie([]byte(cUsersBucket), db)
+	createBucketIfNotExistsOrDie([]byte(cPostsBucket), db)
+	createBucketIfNotExistsOrDie([]byte(cPostsByTimeBucket), db)
+	createBucketIfNotExistsOrDie([]byte(cUserPostsBucket), db)
+	createBucketIfNotExistsOrDie([]byte(cLikesBucket), db)
+	createBucketIfNotExistsOrDie([]byte(cUserLikesBucket), db)
+	is.True(env.readiness().Ready)
+}
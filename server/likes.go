@@ -0,0 +1,361 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"tinywebserver/utils"
+)
+
+// cLikesBucket has a nested bucket for every liked post, keyed by the post ID. The keys of the nested
+// buckets are the IDs of the users who liked the post and the values are empty
+const cLikesBucket = "Likes"
+
+// cUserLikesBucket is the reverse of cLikesBucket, it has a nested bucket for every user who liked
+// posts, keyed by the user ID. The keys of the nested buckets are the IDs of the liked posts
+const cUserLikesBucket = "UserLikes"
+
+const likesPageSize = 50
+
+// postLikesBucket returns the nested bucket with the likes of the post, it's nil when the post has
+// never been liked
+func postLikesBucket(tx *bolt.Tx, postID int) *bolt.Bucket {
+	likesBucket := getBucket(tx, cLikesBucket)
+	if likesBucket == nil {
+		return nil
+	}
+	return likesBucket.Bucket(utils.Itob(postID))
+}
+
+func createPostLikesBucket(tx *bolt.Tx, postID int) (*bolt.Bucket, error) {
+	likesBucket := getBucket(tx, cLikesBucket)
+	if likesBucket == nil {
+		return nil, fmt.Errorf(cLikesBucket + " bucket doesn't exist")
+	}
+	return likesBucket.CreateBucketIfNotExists(utils.Itob(postID))
+}
+
+// userLikesBucket returns the nested bucket with the posts the user liked, it's nil when the user has
+// never liked a post
+func userLikesBucket(tx *bolt.Tx, userID string) *bolt.Bucket {
+	allUserLikes := getBucket(tx, cUserLikesBucket)
+	if allUserLikes == nil {
+		return nil
+	}
+	return allUserLikes.Bucket([]byte(userID))
+}
+
+// addLike records the like in both of the buckets, the caller updates the count of the post
+func addLike(tx *bolt.Tx, postID int, userID string) error {
+	likes, err := createPostLikesBucket(tx, postID)
+	if err != nil {
+		return err
+	}
+	if err := likes.Put([]byte(userID), []byte{}); err != nil {
+		return err
+	}
+	allUserLikes := getBucket(tx, cUserLikesBucket)
+	if allUserLikes == nil {
+		return fmt.Errorf(cUserLikesBucket + " bucket doesn't exist")
+	}
+	userLikes, err := allUserLikes.CreateBucketIfNotExists([]byte(userID))
+	if err != nil {
+		return err
+	}
+	return userLikes.Put(utils.Itob(postID), []byte{})
+}
+
+// removeLike removes the like from both of the buckets, the caller updates the count of the post
+func removeLike(tx *bolt.Tx, postID int, userID string) error {
+	if likes := postLikesBucket(tx, postID); likes != nil {
+		if err := likes.Delete([]byte(userID)); err != nil {
+			return err
+		}
+	}
+	if userLikes := userLikesBucket(tx, userID); userLikes != nil {
+		return userLikes.Delete(utils.Itob(postID))
+	}
+	return nil
+}
+
+// userLikedPostIDs returns the IDs of the posts the user liked in their order
+func userLikedPostIDs(tx *bolt.Tx, userID string) []int {
+	var postIDs []int
+	bucket := userLikesBucket(tx, userID)
+	if bucket == nil {
+		return postIDs
+	}
+	cursor := bucket.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		postIDs = append(postIDs, utils.Btoi(key))
+	}
+	return postIDs
+}
+
+// deletePostLikes removes the likes of the post, it's called in the transaction which deletes the post.
+// Only -fsck deletes the posts of the databases which aren't migrated yet and have no likes bucket
+func deletePostLikes(tx *bolt.Tx, postID int) error {
+	if postLikesBucket(tx, postID) == nil {
+		return nil
+	}
+	for _, liker := range postLikers(tx, postID) {
+		if userLikes := userLikesBucket(tx, liker); userLikes != nil {
+			if err := userLikes.Delete(utils.Itob(postID)); err != nil {
+				return err
+			}
+		}
+	}
+	return getBucket(tx, cLikesBucket).DeleteBucket(utils.Itob(postID))
+}
+
+// postLikers returns the IDs of the users who liked the post in their order
+func postLikers(tx *bolt.Tx, postID int) []string {
+	var likers []string
+	bucket := postLikesBucket(tx, postID)
+	if bucket == nil {
+		return likers
+	}
+	cursor := bucket.Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		likers = append(likers, string(key))
+	}
+	return likers
+}
+
+// unlikeAll removes the likes of the user from all of the posts and updates their counts
+func unlikeAll(tx *bolt.Tx, userID string, stats *deletionStats) error {
+	allUserLikes, postsBucket := getBucket(tx, cUserLikesBucket), getBucket(tx, cPostsBucket)
+	if allUserLikes == nil || postsBucket == nil {
+		return fmt.Errorf("the database isn't initialized")
+	}
+	liked := userLikedPostIDs(tx, userID)
+	for _, postID := range liked {
+		if err := removeLike(tx, postID, userID); err != nil {
+			return err
+		}
+		stats.Unliked++
+		buf := postsBucket.Get(utils.Itob(postID))
+		if buf == nil {
+			continue
+		}
+		post := dbPost{}
+		if err := json.Unmarshal(buf, &post); err != nil {
+			return err
+		}
+		post.LikesCount--
+		buf, err := json.Marshal(post)
+		if err != nil {
+			return err
+		}
+		if err := postsBucket.Put(utils.Itob(postID), buf); err != nil {
+			return err
+		}
+	}
+	if userLikesBucket(tx, userID) == nil {
+		return nil
+	}
+	return allUserLikes.DeleteBucket([]byte(userID))
+}
+
+// moveLikesToBuckets is the migration which moves the Likes arrays of the posts, which were rewritten
+// on every like, into the nested buckets of Likes and keeps their count in the posts
+func moveLikesToBuckets(tx *bolt.Tx) error {
+	likesBucket, err := tx.CreateBucketIfNotExists([]byte(cLikesBucket))
+	if err != nil {
+		return fmt.Errorf("couldn't create %v bucket: %w", cLikesBucket, err)
+	}
+	postsBucket := getBucket(tx, cPostsBucket)
+	// the bucket can't be changed while it's iterated, the posts are collected first
+	posts := map[int]map[string]json.RawMessage{}
+	err = postsBucket.ForEach(func(k, v []byte) error {
+		// the other fields are kept as they are
+		post := map[string]json.RawMessage{}
+		if err := json.Unmarshal(v, &post); err != nil {
+			return fmt.Errorf("post %x: %w", k, err)
+		}
+		posts[utils.Btoi(k)] = post
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for postID, post := range posts {
+		var likes []string
+		if value, ok := post["Likes"]; ok {
+			if err := json.Unmarshal(value, &likes); err != nil {
+				return fmt.Errorf("post %v: %w", postID, err)
+			}
+		}
+		// the repeated likes are counted once
+		likers := map[string]bool{}
+		if len(likes) > 0 {
+			bucket, err := likesBucket.CreateBucketIfNotExists(utils.Itob(postID))
+			if err != nil {
+				return err
+			}
+			for _, liker := range likes {
+				if err := bucket.Put([]byte(liker), []byte{}); err != nil {
+					return err
+				}
+				likers[liker] = true
+			}
+		}
+		delete(post, "Likes")
+		post["LikesCount"] = json.RawMessage(strconv.Itoa(len(likers)))
+		buf, err := json.Marshal(post)
+		if err != nil {
+			return err
+		}
+		if err := postsBucket.Put(utils.Itob(postID), buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexUserLikes is the migration which fills the UserLikes buckets from the likes of the posts
+func indexUserLikes(tx *bolt.Tx) error {
+	allUserLikes, err := tx.CreateBucketIfNotExists([]byte(cUserLikesBucket))
+	if err != nil {
+		return fmt.Errorf("couldn't create %v bucket: %w", cUserLikesBucket, err)
+	}
+	likesBucket := getBucket(tx, cLikesBucket)
+	return likesBucket.ForEach(func(k, v []byte) error {
+		return likesBucket.Bucket(k).ForEach(func(liker, _ []byte) error {
+			userLikes, err := allUserLikes.CreateBucketIfNotExists(liker)
+			if err != nil {
+				return err
+			}
+			return userLikes.Put(k, []byte{})
+		})
+	})
+}
+
+// getPostLikes returns the users who liked the post in the order of their IDs, starting after the
+// user ID after. next is the after of the following page and it's empty on the last one
+func (db *twsDB) getPostLikes(postID int, after string, limit int) (userIDs []string, next string, err error) {
+	err = db.view("getPostLikes", func(tx *bolt.Tx) error {
+		postsBucket := getBucket(tx, cPostsBucket)
+		if postsBucket == nil {
+			return fmt.Errorf(cPostsBucketNotExistError)
+		}
+		if postsBucket.Get(utils.Itob(postID)) == nil {
+			return errPostNotExist
+		}
+		bucket := postLikesBucket(tx, postID)
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		key, _ := cursor.First()
+		if len(after) > 0 {
+			if key, _ = cursor.Seek([]byte(after)); key != nil && string(key) == after {
+				key, _ = cursor.Next()
+			}
+		}
+		for ; key != nil; key, _ = cursor.Next() {
+			if len(userIDs) == limit {
+				next = userIDs[len(userIDs)-1]
+				return nil
+			}
+			userIDs = append(userIDs, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return userIDs, next, nil
+}
+
+// getUserLikes reports which of the posts the user liked
+func (db *twsDB) getUserLikes(userID string, postIDs []int) (liked map[int]bool, err error) {
+	liked = map[int]bool{}
+	err = db.view("getUserLikes", func(tx *bolt.Tx) error {
+		for _, postID := range postIDs {
+			if bucket := postLikesBucket(tx, postID); bucket != nil && bucket.Get([]byte(userID)) != nil {
+				liked[postID] = true
+			}
+		}
+		return nil
+	})
+	return liked, err
+}
+
+// markLiked sets Liked of the posts, and of the posts they repost, which the logged in user liked,
+// so the cards highlight their hearts
+func (env *environment) markLiked(r *http.Request, posts ...*twsPost) {
+	userData, err := env.readUserData(r)
+	if err != nil || len(userData.Id) == 0 {
+		return
+	}
+	var postIDs []int
+	for _, post := range posts {
+		postIDs = append(postIDs, post.PostId)
+		if post.Repost != nil {
+			postIDs = append(postIDs, post.Repost.PostId)
+		}
+	}
+	liked, err := env.requestDB(r).getUserLikes(userData.Id, postIDs)
+	if err != nil {
+		requestLog(r).Warn("couldn't load likes", "user_id", userData.Id, "err", err)
+		return
+	}
+	for _, post := range posts {
+		post.Liked = liked[post.PostId]
+		if post.Repost != nil {
+			post.Repost.Liked = liked[post.Repost.PostId]
+		}
+	}
+}
+
+// PostLike is a user on the likes page of the post
+type PostLike struct {
+	UserID    string
+	AvatarURL string
+}
+
+// PostLikesPage is the view model of the users who liked the post
+type PostLikesPage struct {
+	Post  twsPost
+	Likes []PostLike
+	Next  string
+}
+
+// NextURL links to the following page of the likes
+func (page PostLikesPage) NextURL() string {
+	return "/post/" + strconv.Itoa(page.Post.PostId) + "/likes/?" + url.Values{"after": {page.Next}}.Encode()
+}
+
+// postLikesHandler shows the users who liked the post, likesPageSize of them per page
+func (env *environment) postLikesHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := env.loadRequestedPost(w, r)
+	if !ok {
+		return
+	}
+	db := env.requestDB(r)
+	userIDs, next, err := db.getPostLikes(post.PostId, r.URL.Query().Get("after"), likesPageSize)
+	if errors.Is(err, errPostNotExist) {
+		env.renderError(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	page := PostLikesPage{Post: post, Next: next}
+	for _, userID := range userIDs {
+		like := PostLike{UserID: userID}
+		if user, err := db.getUser(userID); err == nil {
+			like.AvatarURL = user.AvatarUrl
+		} else {
+			requestLog(r).Warn("couldn't load user who liked the post", "user_id", userID, "err", err)
+		}
+		page.Likes = append(page.Likes, like)
+	}
+	env.render(w, r, "post_likes.html", page)
+}
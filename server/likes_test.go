@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"tinywebserver/session"
+	"tinywebserver/utils"
+)
+
+func TestPostLikes(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	for _, userID := range []string{"alice", "bob", "carol", "dave"} {
+		createTestUser(is, db, userID, userID+".png")
+	}
+	postID, err := db.saveUserPost([]byte("alice"), "alice's post")
+	is.NoErr(err)
+	for _, userID := range []string{"dave", "bob", "carol"} {
+		is.NoErr(db.toggleLikeOnUserPost([]byte("alice"), postID, userID))
+	}
+	// the second like of carol takes the first one back
+	is.NoErr(db.toggleLikeOnUserPost([]byte("alice"), postID, "carol"))
+	post, err := db.getUserPost(postID)
+	is.NoErr(err)
+	is.Equal(post.LikesCount, 2)
+
+	likers, next, err := db.getPostLikes(postID, "", 1)
+	is.NoErr(err)
+	is.Equal(likers, []string{"bob"})
+	is.Equal(next, "bob")
+	likers, next, err = db.getPostLikes(postID, next, 1)
+	is.NoErr(err)
+	is.Equal(likers, []string{"dave"})
+	is.Equal(next, "")
+	_, _, err = db.getPostLikes(postID+1, "", 1)
+	is.Equal(err, errPostNotExist)
+
+	liked, err := db.getUserLikes("bob", []int{postID, postID + 1})
+	is.NoErr(err)
+	is.Equal(liked, map[int]bool{postID: true})
+	liked, err = db.getUserLikes("carol", []int{postID})
+	is.NoErr(err)
+	is.Equal(len(liked), 0)
+	likedPosts, err := db.getLikedPosts("dave")
+	is.NoErr(err)
+	is.Equal(postTexts(likedPosts), []string{"alice's post"})
+	likedPosts, err = db.getLikedPosts("carol")
+	is.NoErr(err)
+	is.Equal(len(likedPosts), 0)
+
+	// the likes of a deleted account are taken back from the counts
+	_, err = db.deleteUser("bob", deletionDelete)
+	is.NoErr(err)
+	post, err = db.getUserPost(postID)
+	is.NoErr(err)
+	is.Equal(post.LikesCount, 1)
+	is.NoErr(db.deleteUserPost([]byte("alice"), postID))
+	is.NoErr(db.db.View(func(tx *bolt.Tx) error {
+		is.True(postLikesBucket(tx, postID) == nil)
+		is.True(userLikesBucket(tx, "bob") == nil)
+		is.Equal(len(userLikedPostIDs(tx, "dave")), 0)
+		return nil
+	}))
+}
+
+func TestMoveLikesToBuckets(t *testing.T) {
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	_, err := migrate(db, migrations[:3], false)
+	is.NoErr(err)
+	testDB := &twsDB{db: db}
+	createTestUser(is, testDB, "alice", "alice.png")
+	is.NoErr(db.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(map[string]interface{}{"Text": "old post", "CreationDate": []byte("2022-03-08T10:04:00.000Z"),
+			"CreatorId": []byte("alice"), "Likes": []string{"bob", "carol", "bob"}})
+		if err != nil {
+			return err
+		}
+		return getBucket(tx, cPostsBucket).Put(utils.Itob(1), buf)
+	}))
+
+	_, err = migrate(db, migrations, false)
+	is.NoErr(err)
+	post, err := testDB.getUserPost(1)
+	is.NoErr(err)
+	is.Equal(post.Text, "old post")
+	is.Equal(post.LikesCount, 2)
+	likers, _, err := testDB.getPostLikes(1, "", 10)
+	is.NoErr(err)
+	is.Equal(likers, []string{"bob", "carol"})
+	likedPosts, err := testDB.getLikedPosts("carol")
+	is.NoErr(err)
+	is.Equal(postTexts(likedPosts), []string{"old post"})
+}
+
+func TestPostLikesHandler(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	createTestUser(is, db, "alice", "alice.png")
+	createTestUser(is, db, "bob", "bob.png")
+	postID, err := db.saveUserPost([]byte("alice"), "alice's post")
+	is.NoErr(err)
+	is.NoErr(db.toggleLikeOnUserPost([]byte("alice"), postID, "bob"))
+	env := environment{db: db, sessionManager: session.NewManager("memory", "twssessionid", 3600)}
+	mux := env.routes()
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		loginTestUser(&env, req, TwsUserData{Id: "bob", AvatarUrl: "bob.png"})
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/post/" + strconv.Itoa(postID) + "/likes/")
+	is.Equal(rec.Code, http.StatusOK)
+	body := rec.Body.String()
+	is.True(strings.Contains(body, `href="/profile/bob"`))
+	is.True(strings.Contains(body, `src="bob.png"`))
+	// bob sees the heart of the post they liked highlighted
+	is.True(strings.Contains(body, "tws-liked"))
+
+	is.Equal(get("/post/"+strconv.Itoa(postID+1)+"/likes/").Code, http.StatusNotFound)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"tinywebserver/metrics"
+	"tinywebserver/session"
+)
+
+var metricsRegistry = metrics.NewRegistry()
+
+var (
+	httpRequests = metricsRegistry.NewCounter("tws_http_requests_total",
+		"Number of served HTTP requests.", "route", "method", "status")
+	httpRequestDuration = metricsRegistry.NewHistogram("tws_http_request_duration_seconds",
+		"Time spent serving HTTP requests.", metrics.DefaultBuckets, "route", "method")
+	dbTransactionDuration = metricsRegistry.NewHistogram("tws_db_transaction_duration_seconds",
+		"Duration of BoltDB transactions per database method.", metrics.DefaultBuckets, "method")
+	postsCreated = metricsRegistry.NewCounter("tws_posts_created_total",
+		"Number of created posts by post type.", "type")
+	likesToggled = metricsRegistry.NewCounter("tws_likes_toggled_total",
+		"Number of likes and unlikes.", "action")
+	oauthLogins = metricsRegistry.NewCounter("tws_oauth_logins_total",
+		"Number of OAuth login attempts by result.", "result")
+	dbBackups = metricsRegistry.NewCounter("tws_db_backups_total",
+		"Number of scheduled database backups by result.", "result")
+	accountsDeleted = metricsRegistry.NewCounter("tws_accounts_deleted_total",
+		"Number of deleted user accounts by the policy for their posts.", "policy")
+	rateLimitedRequests = metricsRegistry.NewCounter("tws_rate_limited_requests_total",
+		"Number of requests rejected by the rate limiter.", "route", "key_type")
+)
+
+// registerSessionMetrics exposes the amount of active sessions, must be called once per process
+func registerSessionMetrics(manager *session.Manager) {
+	metricsRegistry.NewGaugeFunc("tws_active_sessions",
+		"Number of active user sessions.", func() float64 {
+			return float64(manager.SessionCount())
+		})
+}
+
+// instrumentRequests records the request count and latency per matched route
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		route := routePattern(r)
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+func postTypeLabel(postType int) string {
+	switch postType {
+	case PostType_Repost:
+		return "repost"
+	case PostType_Quote:
+		return "quote"
+	}
+	return "post"
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tinywebserver/session"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	is := is.New(t)
+	env := environment{
+		db:             &stubDB{pageData: Page{Title: "metricspage", Body: []byte("body")}},
+		sessionManager: session.NewManager("memory", "twssessionid", 3600),
+	}
+	mux := env.routes()
+
+	servedBefore := httpRequests.Value("/view/{title}", http.MethodGet, "200")
+	unmatchedBefore := httpRequests.Value("unmatched", http.MethodGet, "404")
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/view/metricspage", nil))
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/no/such/page", nil))
+	is.Equal(httpRequests.Value("/view/{title}", http.MethodGet, "200"), servedBefore+1)
+	is.Equal(httpRequests.Value("unmatched", http.MethodGet, "404"), unmatchedBefore+1)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	is.True(strings.Contains(rec.Body.String(), `tws_http_requests_total{route="/view/{title}",method="GET",status="200"}`))
+	is.True(strings.Contains(rec.Body.String(), `tws_http_request_duration_seconds_count{route="/view/{title}",method="GET"}`))
+}
+
+func TestDBMetrics(t *testing.T) {
+	t.Parallel()
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	testDB := twsDB{db: db}
+	createBucketIfNotExistsOrDie([]byte("Users"), testDB.db)
+	createBucketIfNotExistsOrDie([]byte("Posts"), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cPostsByTimeBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cUserPostsBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cLikesBucket), testDB.db)
+	createBucketIfNotExistsOrDie([]byte(cUserLikesBucket), testDB.db)
+	_, err := testDB.SyncUser(defaultTestUserData)
+	is.NoErr(err)
+
+	transactionsBefore := dbTransactionDuration.Count("saveUserPost")
+	postsBefore := postsCreated.Value("post")
+	likesBefore := likesToggled.Value("like")
+	userID := []byte(defaultTestUserData.Id)
+	postID, err := testDB.saveUserPost(userID, "metrics post")
+	is.NoErr(err)
+	err = testDB.toggleLikeOnUserPost(userID, postID, defaultTestUserData.Id)
+	is.NoErr(err)
+
+	is.True(dbTransactionDuration.Count("saveUserPost") > transactionsBefore)
+	is.True(postsCreated.Value("post") > postsBefore)
+	is.True(likesToggled.Value("like") > likesBefore)
+}
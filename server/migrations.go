@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"strconv"
+)
+
+const cMetaBucket = "meta"
+
+var cSchemaVersionKey = []byte("schema_version")
+
+// migration upgrades the stored data to its version, it runs in the same transaction which
+// records the new schema version, so a failing migration leaves the database untouched
+type migration struct {
+	version     int
+	description string
+	migrate     func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order at start. Append the new ones with the next version and never
+// change the released ones, the databases in the wild have already run them
+var migrations = []migration{
+	{1, "create the PagesData, Users and Posts buckets", createInitialBuckets},
+	{2, "index the posts by their creation time in PostsByTime", indexPostsByTime},
+	{3, "move the PostsIDs of the users into the nested buckets of UserPosts", moveUserPostsToBuckets},
+	{4, "move the Likes of the posts into the nested buckets of Likes and count them", moveLikesToBuckets},
+	{5, "index the liked posts of the users in UserLikes", indexUserLikes},
+}
+
+func createInitialBuckets(tx *bolt.Tx) error {
+	for _, bucketName := range []string{"PagesData", cUsersBucket, cPostsBucket} {
+		if _, err := tx.CreateBucketIfNotExists([]byte(bucketName)); err != nil {
+			return fmt.Errorf("couldn't create %v bucket: %w", bucketName, err)
+		}
+	}
+	return nil
+}
+
+// schemaVersion returns the version stored in the meta bucket, the databases created before the
+// versioning have none and are at version 0
+func schemaVersion(tx *bolt.Tx) (int, error) {
+	bucket := tx.Bucket([]byte(cMetaBucket))
+	if bucket == nil {
+		return 0, nil
+	}
+	value := bucket.Get(cSchemaVersionKey)
+	if value == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %w", value, err)
+	}
+	return version, nil
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(cMetaBucket))
+	if err != nil {
+		return err
+	}
+	return bucket.Put(cSchemaVersionKey, []byte(strconv.Itoa(version)))
+}
+
+// pendingMigrations returns the migrations newer than the stored schema version. A database
+// written by a newer release is refused, the older code would misread its data
+func pendingMigrations(db *bolt.DB, all []migration) (current int, pending []migration, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		current, err = schemaVersion(tx)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(all) > 0 && current > all[len(all)-1].version {
+		return current, nil, fmt.Errorf("database schema version %v is newer than the supported %v", current, all[len(all)-1].version)
+	}
+	for _, m := range all {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+	return current, pending, nil
+}
+
+// errDryRun rolls back the transaction of the dry run
+var errDryRun = errors.New("dry run")
+
+// migrate applies the pending migrations, each one in its own transaction. The dry run applies all
+// of them in a single transaction and rolls it back, which shows whether they would succeed
+func migrate(db *bolt.DB, all []migration, dryRun bool) (applied []migration, err error) {
+	current, pending, err := pendingMigrations(db, all)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	if dryRun {
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, m := range pending {
+				if err := applyMigration(tx, m); err != nil {
+					return err
+				}
+				applied = append(applied, m)
+			}
+			return errDryRun
+		})
+		if errors.Is(err, errDryRun) {
+			err = nil
+		}
+		return applied, err
+	}
+	for _, m := range pending {
+		if err := db.Update(func(tx *bolt.Tx) error { return applyMigration(tx, m) }); err != nil {
+			return applied, err
+		}
+		serverLog.Info("migration applied", "from_version", current, "version", m.version, "description", m.description)
+		current = m.version
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func applyMigration(tx *bolt.Tx, m migration) error {
+	if err := m.migrate(tx); err != nil {
+		return fmt.Errorf("migration %v (%v) failed: %w", m.version, m.description, err)
+	}
+	return setSchemaVersion(tx, m.version)
+}
+
+// printMigrations lists the applied and the pending migrations
+func printMigrations(db *bolt.DB, all []migration, output io.Writer) error {
+	current, pending, err := pendingMigrations(db, all)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Schema version: %v\n", current)
+	for _, m := range all {
+		status := "applied"
+		if m.version > current {
+			status = "pending"
+		}
+		fmt.Fprintf(output, "%4d  %-8s %v\n", m.version, status, m.description)
+	}
+	if len(pending) == 0 {
+		fmt.Fprintln(output, "The database is up to date.")
+	}
+	return nil
+}
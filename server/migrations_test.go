@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/matryer/is"
+	"strconv"
+	"strings"
+	"testing"
+	"tinywebserver/utils"
+)
+
+func putMigration(version int, key string) migration {
+	return migration{version, "put " + key, func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("test"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), []byte("value"))
+	}}
+}
+
+func storedSchema(is *is.I, db *bolt.DB) (version int, keys []string) {
+	is.NoErr(db.View(func(tx *bolt.Tx) error {
+		var err error
+		version, err = schemaVersion(tx)
+		if bucket := tx.Bucket([]byte("test")); bucket != nil {
+			bucket.ForEach(func(key, value []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			})
+		}
+		return err
+	}))
+	return
+}
+
+func TestMigrate(t *testing.T) {
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	all := []migration{putMigration(1, "a"), putMigration(2, "b")}
+
+	applied, err := migrate(db, all, true)
+	is.NoErr(err)
+	is.Equal(len(applied), 2)
+	// the dry run changes nothing
+	version, keys := storedSchema(is, db)
+	is.Equal(version, 0)
+	is.Equal(len(keys), 0)
+
+	applied, err = migrate(db, all, false)
+	is.NoErr(err)
+	is.Equal(len(applied), 2)
+	version, keys = storedSchema(is, db)
+	is.Equal(version, 2)
+	is.Equal(keys, []string{"a", "b"})
+
+	applied, err = migrate(db, all, false)
+	is.NoErr(err)
+	is.Equal(len(applied), 0)
+
+	// a failing migration is rolled back together with its version, the ones before it stay applied
+	failing := migration{4, "fail", func(tx *bolt.Tx) error {
+		tx.Bucket([]byte("test")).Put([]byte("d"), []byte("value"))
+		return fmt.Errorf("broken data")
+	}}
+	all = append(all, putMigration(3, "c"), failing)
+	applied, err = migrate(db, all, false)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "migration 4 (fail) failed: broken data"))
+	is.Equal(len(applied), 1)
+	version, keys = storedSchema(is, db)
+	is.Equal(version, 3)
+	is.Equal(keys, []string{"a", "b", "c"})
+
+	// the code older than the database refuses it
+	_, err = migrate(db, all[:2], false)
+	is.True(err != nil)
+	is.True(strings.Contains(err.Error(), "newer than the supported 2"))
+}
+
+func TestPrintMigrations(t *testing.T) {
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	all := []migration{putMigration(1, "a"), putMigration(2, "b")}
+	_, err := migrate(db, all[:1], false)
+	is.NoErr(err)
+
+	var output bytes.Buffer
+	is.NoErr(printMigrations(db, all, &output))
+	is.True(strings.Contains(output.String(), "Schema version: 1"))
+	is.True(strings.Contains(output.String(), "   1  applied  put a"))
+	is.True(strings.Contains(output.String(), "   2  pending  put b"))
+}
+
+func TestInitialMigrations(t *testing.T) {
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	// the databases created before the versioning already have the buckets
+	createBucketIfNotExistsOrDie([]byte(cUsersBucket), db)
+
+	_, err := migrate(db, migrations, false)
+	is.NoErr(err)
+	is.NoErr((&twsDB{db: db}).checkHealth())
+	version, _ := storedSchema(is, db)
+	is.Equal(version, migrations[len(migrations)-1].version)
+}
+
+func TestMoveUserPostsToBuckets(t *testing.T) {
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	_, err := migrate(db, migrations[:2], false)
+	is.NoErr(err)
+	testDB := &twsDB{db: db}
+	is.NoErr(db.Update(func(tx *bolt.Tx) error {
+		for _, id := range []int{1, 2, 3} {
+			post := dbPost{Text: "post " + strconv.Itoa(id), CreationDate: []byte("2022-03-08T10:04:00.000Z"), CreatorId: []byte("alice")}
+			buf, err := json.Marshal(post)
+			if err != nil {
+				return err
+			}
+			if err := getBucket(tx, cPostsBucket).Put(utils.Itob(id), buf); err != nil {
+				return err
+			}
+		}
+		users := getBucket(tx, cUsersBucket)
+		if err := users.Put([]byte("alice"), []byte(`{"AvatarUrl":"alice.png","AdminRight":1,"PostsIDs":[1,2,3],"Locale":"uk"}`)); err != nil {
+			return err
+		}
+		return users.Put([]byte("bob"), []byte(`{"AvatarUrl":"bob.png","AdminRight":0,"PostsIDs":null}`))
+	}))
+
+	_, err = migrate(db, migrations, false)
+	is.NoErr(err)
+	postIDs, err := testDB.getUserPostIDs("alice")
+	is.NoErr(err)
+	is.Equal(postIDs, []int{1, 2, 3})
+	alice, err := testDB.getUser("alice")
+	is.NoErr(err)
+	is.Equal(alice, dbUserData{AvatarUrl: "alice.png", AdminRight: ADMIN, Locale: "uk"})
+	is.NoErr(db.View(func(tx *bolt.Tx) error {
+		is.True(!strings.Contains(string(getBucket(tx, cUsersBucket).Get([]byte("alice"))), "PostsIDs"))
+		is.Equal(userPostsBucket(tx, []byte("bob")), nil)
+		return nil
+	}))
+
+	// the posts are read from the bucket the newest first, the last key continues from the post
+	posts, err := testDB.getLatestUserPosts([]byte("alice"), 2, 0)
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"post 3", "post 2"})
+	posts, err = testDB.getLatestUserPosts([]byte("alice"), 2, 2)
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"post 2", "post 1"})
+	_, err = testDB.getLatestUserPosts([]byte("alice"), 2, 7)
+	is.True(err != nil)
+	posts, err = testDB.getLatestUserPosts([]byte("bob"), 2, 0)
+	is.NoErr(err)
+	is.Equal(len(posts), 0)
+}
@@ -7,12 +7,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"golang.org/x/oauth2"
-	"gopkg.in/yaml.v3"
-	"log"
 	"math/rand"
 	"net/http"
-	"os"
 	"time"
+	"tinywebserver/config"
 	"tinywebserver/utils"
 )
 
@@ -48,32 +46,16 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-type OauthData struct {
-	Auth_github_cid string
-	Auth_github_csec string
-}
-
 type SuperAdminStruct struct {
 	SuperAdminId string `yaml:"super_admin_id"`
 }
 
-func loadOauthConfig() iOauth {
-	cfg, err := os.ReadFile("config/config.yml")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	oauth := OauthData{}
-	err = yaml.Unmarshal(cfg, &oauth)
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func newOauth(cfg config.GithubConfig) iOauth {
 	//TODO: remove hardcoded scopes and URLs
 	return &twsOauth{
 		config: &oauth2.Config{
-			ClientID: oauth.Auth_github_cid,
-			ClientSecret: oauth.Auth_github_csec,
+			ClientID: cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
 			Scopes:			[]string{"user"},
 			Endpoint: oauth2.Endpoint{
 				AuthURL:	"https://github.com/login/oauth/authorize",
@@ -88,7 +70,7 @@ var randomStateString string
 func (env *environment) loginHandler(w http.ResponseWriter, r *http.Request) {
 	randomStateString = utils.RandString(32)
 	url := env.oauth.AuthCodeURL(randomStateString)
-	log.Printf("Visit the URL for the auth dialog: %v", url)
+	requestLog(r).Debug("redirecting to the auth dialog")
 
 	http.Redirect(w, r, url, http.StatusFound)
 }
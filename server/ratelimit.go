@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"tinywebserver/config"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per key, the buckets refill with rate tokens per second up to burst
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(budget config.RateLimitBudget) *rateLimiter {
+	burst := budget.Burst
+	if burst == 0 {
+		burst = budget.Requests
+	}
+	return &rateLimiter{
+		rate:    float64(budget.Requests) / budget.Per.Seconds(),
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow takes a token from the key bucket, when it's empty the time until the next token is returned
+func (limiter *rateLimiter) allow(key string) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	now := limiter.now()
+	limiter.sweep(now)
+
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := (1 - bucket.tokens) / limiter.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// sweep forgets the buckets which are full again, so the idle clients don't take memory
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < time.Minute {
+		return
+	}
+	limiter.lastSweep = now
+	refillTime := time.Duration(limiter.burst / limiter.rate * float64(time.Second))
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.last) >= refillTime {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// clientIP returns the address of the client. X-Forwarded-For is walked from the right and only
+// the hops added by the trusted proxies are skipped, so the client can't spoof its address
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteIP); err == nil {
+		remoteIP = host
+	}
+	if !isTrustedProxy(remoteIP, trustedProxies) {
+		return remoteIP
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		if !isTrustedProxy(hop, trustedProxies) {
+			return hop
+		}
+		remoteIP = hop
+	}
+	return remoteIP
+}
+
+func isTrustedProxy(address string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimits keeps the limiters of the routes with a budget
+type rateLimits struct {
+	limiters       map[string]*rateLimiter
+	trustedProxies []*net.IPNet
+}
+
+func newRateLimits(cfg config.RateLimitConfig) (*rateLimits, error) {
+	trustedProxies, err := cfg.TrustedNetworks()
+	if err != nil {
+		return nil, err
+	}
+	limits := &rateLimits{limiters: map[string]*rateLimiter{}, trustedProxies: trustedProxies}
+	if !cfg.Enabled {
+		return limits, nil
+	}
+	for pattern, budget := range cfg.Routes {
+		if budget.Requests > 0 {
+			limits.limiters[pattern] = newRateLimiter(budget)
+		}
+	}
+	return limits, nil
+}
+
+// rateLimit throttles the requests of the matched route, the logged in users are counted by their ID
+// and everybody else by the client IP
+func (env *environment) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if env.rateLimits == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := routePattern(r)
+		limiter, ok := env.rateLimits.limiters[route]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, keyType := "", "user"
+		if userData, err := env.readUserData(r); err == nil && len(userData.Id) > 0 {
+			key = "user:" + userData.Id
+		} else {
+			keyType = "ip"
+			key = "ip:" + clientIP(r, env.rateLimits.trustedProxies)
+		}
+		allowed, retryAfter := limiter.allow(key)
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rateLimitedRequests.Inc(route, keyType)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		env.renderError(w, r, http.StatusTooManyRequests, fmt.Errorf("rate limit of %v exceeded by %v", route, keyType))
+	})
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"tinywebserver/config"
+	"tinywebserver/session"
+)
+
+func TestRateLimiter(t *testing.T) {
+	is := is.New(t)
+	now := time.Now()
+	limiter := newRateLimiter(config.RateLimitBudget{Requests: 6, Per: time.Minute, Burst: 2})
+	limiter.now = func() time.Time { return now }
+
+	allowed, _ := limiter.allow("a")
+	is.True(allowed)
+	allowed, _ = limiter.allow("a")
+	is.True(allowed)
+	allowed, retryAfter := limiter.allow("a")
+	is.True(!allowed)
+	is.Equal(retryAfter, 10*time.Second)
+
+	// the other keys have their own buckets
+	allowed, _ = limiter.allow("b")
+	is.True(allowed)
+
+	now = now.Add(10 * time.Second)
+	allowed, _ = limiter.allow("a")
+	is.True(allowed)
+	allowed, _ = limiter.allow("a")
+	is.True(!allowed)
+
+	// the refilled buckets are forgotten
+	now = now.Add(time.Hour)
+	limiter.allow("c")
+	is.Equal(len(limiter.buckets), 1)
+}
+
+func TestClientIP(t *testing.T) {
+	trustedProxies, err := config.RateLimitConfig{TrustedProxies: []string{"10.0.0.0/8", "::1"}}.TrustedNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor []string
+		expectedIP   string
+	}{
+		{"203.0.113.5:1234", nil, "203.0.113.5"},
+		{"203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"10.0.0.2:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.2:1234", []string{"6.6.6.6, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"10.0.0.2:1234", []string{"6.6.6.6", "198.51.100.1"}, "198.51.100.1"},
+		{"[::1]:1234", []string{"garbage"}, "::1"},
+		{"10.0.0.2:1234", nil, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwardedFor {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if ip := clientIP(req, trustedProxies); ip != tt.expectedIP {
+			t.Errorf("client IP of %v with %v should be %v, got %v", tt.remoteAddr, tt.forwardedFor, tt.expectedIP, ip)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	is := is.New(t)
+	limits, err := newRateLimits(config.RateLimitConfig{
+		Enabled: true,
+		Routes:  map[string]config.RateLimitBudget{"/login": {Requests: 1, Per: time.Minute}},
+	})
+	is.NoErr(err)
+	env := environment{
+		db:             &stubDB{},
+		oauth:          &stubOauth{},
+		sessionManager: session.NewManager("memory", "twssessionid", 3600),
+		rateLimits:     limits,
+	}
+	mux := env.routes()
+	serve := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	is.Equal(serve("/login", "192.0.2.1:1000").Code, http.StatusFound)
+	throttledBefore := rateLimitedRequests.Value("/login", "ip")
+	rec := serve("/login", "192.0.2.1:1001")
+	is.Equal(rec.Code, http.StatusTooManyRequests)
+	is.Equal(rec.Header().Get("Retry-After"), "60")
+	is.Equal(rateLimitedRequests.Value("/login", "ip"), throttledBefore+1)
+
+	is.Equal(serve("/login", "192.0.2.2:1000").Code, http.StatusFound)
+	is.Equal(serve("/healthz", "192.0.2.1:1000").Code, http.StatusOK)
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// middleware wraps a handler with additional behaviour (logging, auth checks, etc.)
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h with the middlewares, the first one being the outermost
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+type routeParamsKey struct{}
+type routeMatchKey struct{}
+
+// routeMatch is placed into the request context before the router middlewares run and is
+// filled once the route is matched, so the middlewares can see the route pattern afterwards
+type routeMatch struct {
+	pattern string
+}
+
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+}
+
+// match compares the path segments with the route pattern. Segments in braces like {id} are
+// captured as parameters, {name...} captures the rest of the path
+func (rte *route) match(pathSegments []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, segment := range rte.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "...}") {
+			if i >= len(pathSegments) {
+				return nil, false
+			}
+			params[segment[1:len(segment)-4]] = strings.Join(pathSegments[i:], "/")
+			return params, true
+		}
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if len(pathSegments[i]) == 0 {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = pathSegments[i]
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
+		}
+	}
+	if len(rte.segments) != len(pathSegments) {
+		return nil, false
+	}
+	return params, true
+}
+
+func (rte *route) allowedMethods() string {
+	methods := make([]string, 0, len(rte.handlers))
+	for method := range rte.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// router dispatches requests by path pattern and HTTP method. Patterns are matched in the
+// order of registration
+type router struct {
+	routes           []*route
+	middlewares      []middleware
+	routeMiddlewares []middleware
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+}
+
+func newRouter() *router {
+	return &router{
+		notFound: http.HandlerFunc(http.NotFound),
+		methodNotAllowed: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}),
+	}
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// use adds middlewares which are applied to every route of the router
+func (rt *router) use(middlewares ...middleware) {
+	rt.middlewares = append(rt.middlewares, middlewares...)
+}
+
+// useForRoutes adds middlewares which run after the route is matched, before the per-route ones.
+// Unlike use, they only apply to the routes registered afterwards
+func (rt *router) useForRoutes(middlewares ...middleware) {
+	rt.routeMiddlewares = append(rt.routeMiddlewares, middlewares...)
+}
+
+func (rt *router) handle(method string, pattern string, h http.HandlerFunc, middlewares ...middleware) {
+	var r *route
+	for _, existing := range rt.routes {
+		if existing.pattern == pattern {
+			r = existing
+			break
+		}
+	}
+	if r == nil {
+		r = &route{pattern: pattern, segments: splitPath(pattern), handlers: map[string]http.Handler{}}
+		rt.routes = append(rt.routes, r)
+	}
+	allMiddlewares := append(append([]middleware{}, rt.routeMiddlewares...), middlewares...)
+	r.handlers[method] = chain(h, allMiddlewares...)
+}
+
+// get registers the handler for both GET and HEAD requests
+func (rt *router) get(pattern string, h http.HandlerFunc, middlewares ...middleware) {
+	rt.handle(http.MethodGet, pattern, h, middlewares...)
+	rt.handle(http.MethodHead, pattern, h, middlewares...)
+}
+
+func (rt *router) post(pattern string, h http.HandlerFunc, middlewares ...middleware) {
+	rt.handle(http.MethodPost, pattern, h, middlewares...)
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(context.WithValue(r.Context(), routeMatchKey{}, &routeMatch{}))
+	chain(http.HandlerFunc(rt.dispatch), rt.middlewares...).ServeHTTP(w, r)
+}
+
+func (rt *router) dispatch(w http.ResponseWriter, r *http.Request) {
+	pathSegments := splitPath(r.URL.Path)
+	for _, route := range rt.routes {
+		params, ok := route.match(pathSegments)
+		if !ok {
+			continue
+		}
+		handler, ok := route.handlers[r.Method]
+		if !ok {
+			w.Header().Set("Allow", route.allowedMethods())
+			rt.methodNotAllowed.ServeHTTP(w, r)
+			return
+		}
+		if match, ok := r.Context().Value(routeMatchKey{}).(*routeMatch); ok {
+			match.pattern = route.pattern
+		}
+		ctx := context.WithValue(r.Context(), routeParamsKey{}, params)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+	rt.notFound.ServeHTTP(w, r)
+}
+
+// pathParam returns the value of the named path parameter of the matched route
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(routeParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+// routePattern returns the pattern of the matched route, e.g. /profile/{id}
+func routePattern(r *http.Request) string {
+	if match, ok := r.Context().Value(routeMatchKey{}).(*routeMatch); ok {
+		return match.pattern
+	}
+	return ""
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		requestLog(r).Info("request served", "method", r.Method, "path", r.URL.Path, "route", routePattern(r), "status", rec.status, "bytes", rec.size, "duration", time.Since(start))
+	})
+}
+
+type userDataKey struct{}
+
+// requireAuth lets through only requests with a logged in session, the user data is stored
+// inside request context
+func (env *environment) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userData, err := env.readUserData(r)
+		if err != nil || len(userData.Id) == 0 {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		// the account may have been deleted by an admin while the session was alive
+		if _, err := env.requestDB(r).getUser(userData.Id); errors.Is(err, errUserNotExist) {
+			env.sessionManager.DestroySession(w, r)
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userDataKey{}, userData)))
+	})
+}
+
+// requireAdmin must be chained after requireAuth
+func (env *environment) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userData, ok := r.Context().Value(userDataKey{}).(TwsUserData)
+		if !ok || userData.AdminRight != ADMIN {
+			env.renderError(w, r, http.StatusForbidden, fmt.Errorf("user [%s] isn't an admin", userData.Id))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (env *environment) routes() *http.ServeMux {
+	rt := newRouter()
+	rt.use(withRequestID, logRequests, instrumentRequests)
+	if env.compression.Enabled {
+		rt.use(compressResponses(env.compression.MinSize))
+	}
+	rt.use(env.securityHeaders, env.recoverPanics)
+	if env.tls.Enabled() && env.tls.HSTSMaxAge > 0 {
+		rt.use(strictTransportSecurity(env.tls))
+	}
+	rt.notFound = http.HandlerFunc(env.notFoundHandler)
+	rt.methodNotAllowed = http.HandlerFunc(env.methodNotAllowedHandler)
+	rt.useForRoutes(env.rateLimit)
+
+	authorized := []middleware{env.requireAuth}
+	admin := []middleware{env.requireAuth, env.requireAdmin}
+	authorizedForm := []middleware{env.requireAuth, env.checkCSRF}
+	adminForm := []middleware{env.requireAuth, env.requireAdmin, env.checkCSRF}
+
+	rt.get("/", rootHandler)
+	rt.get("/profile", env.profileHandler, authorized...)
+	rt.get("/profile/{id}", env.profileHandler, authorized...)
+	rt.get("/post/{id}", env.postHandler, authorized...)
+	rt.get("/post/{id}/embed", env.embedPostHandler, env.allowEmbedding)
+	rt.get("/post/{id}/likes", env.postLikesHandler, authorized...)
+	rt.get("/explore", env.exploreHandler)
+	rt.get("/compose_post", env.composePostHandler, authorized...)
+	rt.post("/save_post", env.savePostHandler, authorizedForm...)
+	rt.post("/locale", env.localeHandler, authorizedForm...)
+	rt.get("/settings/export", env.takeoutPageHandler, authorized...)
+	rt.post("/settings/export", env.startTakeoutHandler, authorizedForm...)
+	rt.get("/settings/export/{token}", env.downloadTakeoutHandler, authorized...)
+	rt.get("/settings/account", env.accountSettingsHandler, authorized...)
+	rt.post("/settings/account/delete", env.deleteAccountHandler, authorizedForm...)
+	rt.post("/delete_post", env.deletePostHandler, authorizedForm...)
+	rt.post("/like_post", env.likePostHandler, authorizedForm...)
+	rt.get("/view/{title}", env.viewHandler)
+	rt.get("/edit/{title}", env.editHandler, admin...)
+	rt.post("/save/{title}", env.saveHandler, adminForm...)
+	rt.get("/admin/backup", env.backupHandler, admin...)
+	rt.post("/admin/users/{id}/delete", env.adminDeleteUserHandler, adminForm...)
+	rt.get("/github", env.githubHandler)
+	rt.get("/login", env.loginHandler)
+	rt.get("/logout", env.logoutHandler)
+	rt.get("/tmpl/css/{file...}", env.staticHandler)
+	rt.get("/frontend/{file...}", env.staticHandler)
+	rt.get("/img/{file...}", env.staticHandler)
+	rt.get("/metrics", metricsRegistry.Handler().ServeHTTP)
+	rt.get("/healthz", env.healthzHandler)
+	rt.get("/readyz", env.readyzHandler)
+	rt.get("/version", env.versionHandler)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", rt)
+	return mux
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouterPathParams(t *testing.T) {
+	is := is.New(t)
+
+	rt := newRouter()
+	var actualParams map[string]string
+	var actualPattern string
+	recordParams := func(w http.ResponseWriter, r *http.Request) {
+		actualParams = map[string]string{"id": pathParam(r, "id"), "file": pathParam(r, "file")}
+		actualPattern = routePattern(r)
+	}
+	rt.get("/profile", recordParams)
+	rt.get("/profile/{id}", recordParams)
+	rt.get("/img/icons/{file...}", recordParams)
+
+	tbl := []struct {
+		path, expectedPattern, expectedID, expectedFile string
+		expectedCode                                    int
+	}{
+		{"/profile", "/profile", "", "", http.StatusOK},
+		{"/profile/", "/profile", "", "", http.StatusOK},
+		{"/profile/123abc", "/profile/{id}", "123abc", "", http.StatusOK},
+		{"/profile/123abc/", "/profile/{id}", "123abc", "", http.StatusOK},
+		{"/img/icons/heart.png", "/img/icons/{file...}", "", "heart.png", http.StatusOK},
+		{"/img/icons/dir/heart.png", "/img/icons/{file...}", "", "dir/heart.png", http.StatusOK},
+		{"/img/icons", "", "", "", http.StatusNotFound},
+		{"/profile/123abc/posts", "", "", "", http.StatusNotFound},
+		{"/invalidparent/title", "", "", "", http.StatusNotFound},
+	}
+	for _, tt := range tbl {
+		actualParams, actualPattern = nil, ""
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		is.Equal(rec.Code, tt.expectedCode)
+		is.Equal(actualPattern, tt.expectedPattern)
+		if tt.expectedCode == http.StatusOK {
+			is.Equal(actualParams["id"], tt.expectedID)
+			is.Equal(actualParams["file"], tt.expectedFile)
+		}
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	is := is.New(t)
+
+	rt := newRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	rt.get("/save/{title}", ok)
+	rt.post("/save/{title}", ok)
+	rt.post("/save_post", ok)
+
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/save/title", nil))
+	is.Equal(rec.Code, http.StatusOK)
+
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/save_post", nil))
+	is.Equal(rec.Code, http.StatusMethodNotAllowed)
+	is.Equal(rec.Header().Get("Allow"), http.MethodPost)
+
+	rec = httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/save/title", nil))
+	is.Equal(rec.Code, http.StatusMethodNotAllowed)
+	is.Equal(rec.Header().Get("Allow"), "GET, HEAD, POST")
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	is := is.New(t)
+
+	var calls []string
+	record := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	rt := newRouter()
+	rt.use(record("global"))
+	rt.useForRoutes(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, "route "+routePattern(r))
+			next.ServeHTTP(w, r)
+		})
+	})
+	rt.get("/", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}, record("first"), record("second"))
+
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	is.Equal(strings.Join(calls, ","), "global,route /,first,second,handler")
+}
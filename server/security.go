@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"tinywebserver/config"
+)
+
+const (
+	cdnVueURL        = "https://cdn.jsdelivr.net/npm/vue@2.6.14/dist/vue.js"
+	selfHostedVueURL = "/frontend/js/vue.min.js"
+)
+
+type nonceKey struct{}
+
+// PageSecurity carries the values the templates need to comply with the Content Security Policy
+type PageSecurity struct {
+	// Nonce must be set on every inline or external <script> tag
+	Nonce  string
+	VueURL string
+}
+
+func (env *environment) pageSecurity(r *http.Request) PageSecurity {
+	vueURL := cdnVueURL
+	if env.security.SelfHostVue {
+		vueURL = selfHostedVueURL
+	}
+	return PageSecurity{Nonce: cspNonce(r), VueURL: vueURL}
+}
+
+// cspNonce returns the nonce generated for the request by securityHeaders
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey{}).(string)
+	return nonce
+}
+
+// newSecureToken returns 16 bytes of crypto/rand for the values which must not be guessed, e.g. the
+// CSP nonces, the CSRF tokens and the takeout links
+func newSecureToken() string {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		panic("couldn't generate secure token: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func contentSecurityPolicy(cfg config.SecurityConfig, nonce string, frameAncestors string) string {
+	if len(cfg.ContentSecurityPolicy) == 0 {
+		return ""
+	}
+	policy := strings.Replace(cfg.ContentSecurityPolicy, "{nonce}", nonce, -1)
+	if len(frameAncestors) > 0 {
+		policy = strings.TrimRight(policy, "; ") + "; frame-ancestors " + frameAncestors
+	}
+	return policy
+}
+
+func setHeaderIfNotEmpty(header http.Header, name, value string) {
+	if len(value) > 0 {
+		header.Set(name, value)
+	}
+}
+
+// securityHeaders generates the request nonce and sets the security headers on every response
+func (env *environment) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := newSecureToken()
+		header := w.Header()
+		setHeaderIfNotEmpty(header, "Content-Security-Policy", contentSecurityPolicy(env.security, nonce, env.security.FrameAncestors))
+		setHeaderIfNotEmpty(header, "X-Content-Type-Options", env.security.ContentTypeOptions)
+		setHeaderIfNotEmpty(header, "X-Frame-Options", env.security.FrameOptions)
+		setHeaderIfNotEmpty(header, "Referrer-Policy", env.security.ReferrerPolicy)
+		setHeaderIfNotEmpty(header, "Permissions-Policy", env.security.PermissionsPolicy)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce)))
+	})
+}
+
+// allowEmbedding lets other sites put the page into a frame, must run after securityHeaders
+func (env *environment) allowEmbedding(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Del("X-Frame-Options")
+		header.Del("Content-Security-Policy")
+		setHeaderIfNotEmpty(header, "Content-Security-Policy", contentSecurityPolicy(env.security, cspNonce(r), env.security.EmbedFrameAncestors))
+		next.ServeHTTP(w, r)
+	})
+}
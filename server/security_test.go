@@ -0,0 +1,72 @@
+package server
+
+import (
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tinywebserver/config"
+	"tinywebserver/session"
+)
+
+func nonceFromPolicy(policy string) string {
+	start := strings.Index(policy, "'nonce-")
+	if start < 0 {
+		return ""
+	}
+	policy = policy[start+len("'nonce-"):]
+	return policy[:strings.Index(policy, "'")]
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	is := is.New(t)
+	env := environment{
+		db:             &stubDB{},
+		sessionManager: session.NewManager("memory", "twssessionid", 3600),
+		security:       config.Default().Security,
+	}
+	mux := env.routes()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	policy := rec.Header().Get("Content-Security-Policy")
+	is.True(strings.HasSuffix(policy, "; frame-ancestors 'none'"))
+	is.Equal(rec.Header().Get("X-Frame-Options"), "DENY")
+	is.Equal(rec.Header().Get("X-Content-Type-Options"), "nosniff")
+	is.Equal(rec.Header().Get("Referrer-Policy"), "strict-origin-when-cross-origin")
+	is.True(len(rec.Header().Get("Permissions-Policy")) > 0)
+	firstNonce := nonceFromPolicy(policy)
+	is.True(len(firstNonce) > 0)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.True(nonceFromPolicy(rec.Header().Get("Content-Security-Policy")) != firstNonce)
+
+	// the nonce of the header is the one rendered into the scripts
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	loginTestUser(&env, req, defaultTestUserData)
+	mux.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusOK)
+	nonce := nonceFromPolicy(rec.Header().Get("Content-Security-Policy"))
+	is.True(strings.Contains(rec.Body.String(), `<script src="`+cdnVueURL+`" nonce="`+nonce+`"></script>`))
+
+	// the embeddable post may be framed by any site
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/post/1/embed", nil))
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Header().Get("X-Frame-Options"), "")
+	is.True(strings.HasSuffix(rec.Header().Get("Content-Security-Policy"), "; frame-ancestors *"))
+}
+
+func TestSelfHostedVue(t *testing.T) {
+	is := is.New(t)
+	env := environment{security: config.SecurityConfig{SelfHostVue: true}}
+	is.Equal(env.pageSecurity(httptest.NewRequest(http.MethodGet, "/", nil)).VueURL, selfHostedVueURL)
+
+	// the empty values omit the headers
+	rec := httptest.NewRecorder()
+	env.securityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	is.Equal(len(rec.Header()), 0)
+}
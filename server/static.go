@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// staticPrefixes are the asset directories served to the browsers, the templates themselves aren't
+var staticPrefixes = []string{"frontend/", "img/", "tmpl/css/"}
+
+const (
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "no-cache"
+	staticHashLength       = 12
+)
+
+type staticFile struct {
+	name    string
+	content []byte
+	hash    string
+	modTime time.Time
+	// encoded holds the precompressed content by the content coding, e.g. br
+	encoded map[string][]byte
+}
+
+func newStaticFile(name string, content []byte, modTime time.Time) *staticFile {
+	sum := sha256.Sum256(content)
+	return &staticFile{name: name, content: content, hash: hex.EncodeToString(sum[:])[:staticHashLength], modTime: modTime}
+}
+
+func (file *staticFile) hashedName() string {
+	ext := path.Ext(file.name)
+	return strings.TrimSuffix(file.name, ext) + "." + file.hash + ext
+}
+
+// staticAssets serves the files of the static directories. The cached assets are read and hashed once,
+// e.g. from the embedded FS, otherwise every request reads the file, which suits editing them on disk
+type staticAssets struct {
+	fsys    fs.FS
+	cached  bool
+	files   map[string]*staticFile
+	hashed  map[string]*staticFile
+	modTime time.Time
+}
+
+func isStaticFile(name string) bool {
+	for _, prefix := range staticPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// newStaticAssets indexes the static files. The embedded files have no modification time, so the
+// time of the start is reported as their Last-Modified
+func newStaticAssets(fsys fs.FS, cached bool) (*staticAssets, error) {
+	assets := &staticAssets{
+		fsys:    fsys,
+		cached:  cached,
+		files:   map[string]*staticFile{},
+		hashed:  map[string]*staticFile{},
+		modTime: time.Now().UTC().Truncate(time.Second),
+	}
+	if !cached {
+		return assets, nil
+	}
+	for _, prefix := range staticPrefixes {
+		root := strings.TrimSuffix(prefix, "/")
+		if _, err := fs.Stat(fsys, root); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		err := fs.WalkDir(fsys, root, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			file, err := assets.readFile(name)
+			if err != nil {
+				return err
+			}
+			assets.files[name] = file
+			assets.hashed[file.hashedName()] = file
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("couldn't index static files: %w", err)
+		}
+	}
+	return assets, nil
+}
+
+func (assets *staticAssets) readFile(name string) (*staticFile, error) {
+	info, err := fs.Stat(assets.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	content, err := fs.ReadFile(assets.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	modTime := assets.modTime
+	if !info.ModTime().IsZero() {
+		modTime = info.ModTime()
+	}
+	return newStaticFile(name, content, modTime), nil
+}
+
+// url returns the address of the asset, the cached assets get the content hash in the file name,
+// so they can be cached by the browsers forever
+func (assets *staticAssets) url(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if assets != nil && assets.cached {
+		if file, ok := assets.files[name]; ok {
+			return "/" + file.hashedName()
+		}
+	}
+	return "/" + name
+}
+
+// lookup finds the file by its plain or hashed name, immutable is true for the hashed one
+func (assets *staticAssets) lookup(name string) (file *staticFile, immutable bool, err error) {
+	if !isStaticFile(name) {
+		return nil, false, fs.ErrNotExist
+	}
+	if !assets.cached {
+		file, err = assets.readFile(name)
+		return file, false, err
+	}
+	if file, ok := assets.hashed[name]; ok {
+		return file, true, nil
+	}
+	if file, ok := assets.files[name]; ok {
+		return file, false, nil
+	}
+	return nil, false, fs.ErrNotExist
+}
+
+// precompress compresses the cached files of at least minSize bytes with all of the supported codings.
+// The files compressed at build time, e.g. bulma.css.br next to bulma.css, are used instead when present
+func (assets *staticAssets) precompress(minSize int) error {
+	if !assets.cached {
+		return nil
+	}
+	for name, file := range assets.files {
+		if len(file.content) < minSize || !compressible(mime.TypeByExtension(path.Ext(name))) {
+			continue
+		}
+		file.encoded = map[string][]byte{}
+		for _, encoding := range compressionEncodings {
+			content, err := assets.compressFile(file, encoding)
+			if err != nil {
+				return fmt.Errorf("couldn't compress %v: %w", name, err)
+			}
+			// compressing doesn't pay off for every file
+			if len(content) < len(file.content) {
+				file.encoded[encoding] = content
+			}
+		}
+	}
+	return nil
+}
+
+var encodingExtensions = map[string]string{"br": ".br", "gzip": ".gz"}
+
+func (assets *staticAssets) compressFile(file *staticFile, encoding string) ([]byte, error) {
+	content, err := fs.ReadFile(assets.fsys, file.name+encodingExtensions[encoding])
+	if err == nil {
+		return content, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := newEncoder(encoding, &buf, true)
+	if _, err := enc.Write(file.content); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodings returns the codings the file was precompressed with in the order of preference
+func (file *staticFile) encodings() []string {
+	var encodings []string
+	for _, encoding := range compressionEncodings {
+		if _, ok := file.encoded[encoding]; ok {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+func (env *environment) staticHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	file, immutable, err := env.static.lookup(name)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, fs.ErrNotExist) {
+			code = http.StatusNotFound
+		}
+		env.renderError(w, r, code, err)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(file.name))
+	if len(contentType) == 0 {
+		contentType = http.DetectContentType(file.content)
+	}
+	w.Header().Set("Content-Type", contentType)
+	if immutable {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", revalidateCacheControl)
+	}
+
+	content, etag := file.content, file.hash
+	if len(file.encoded) > 0 {
+		addVary(w.Header(), "Accept-Encoding")
+		if encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), file.encodings()); len(encoding) > 0 {
+			w.Header().Set("Content-Encoding", encoding)
+			content, etag = file.encoded[encoding], file.hash+"-"+encoding
+		}
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, file.name, file.modTime, bytes.NewReader(content))
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var testAssets = fstest.MapFS{
+	"tmpl/edit.html":         {Data: []byte("<html></html>")},
+	"tmpl/css/tws-style.css": {Data: []byte("body {}")},
+	"frontend/js/main.js":    {Data: []byte("console.log(1)")},
+	"img/icons/heart.png":    {Data: []byte("\x89PNG\r\n\x1a\n"), ModTime: time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC)},
+	"frontend/css/bulma.css": {Data: []byte(".button {}")},
+}
+
+func serveStatic(env *environment, path string, header http.Header) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	env.routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStaticAssets(t *testing.T) {
+	is := is.New(t)
+	static, err := newStaticAssets(testAssets, true)
+	is.NoErr(err)
+	env := environment{db: &stubDB{}, static: static}
+
+	hashedURL := static.url("tmpl/css/tws-style.css")
+	is.True(strings.HasPrefix(hashedURL, "/tmpl/css/tws-style."))
+	is.True(strings.HasSuffix(hashedURL, ".css"))
+	is.Equal(static.url("no/such/file.css"), "/no/such/file.css")
+
+	rec := serveStatic(&env, hashedURL, nil)
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Body.String(), "body {}")
+	is.True(strings.HasPrefix(rec.Header().Get("Content-Type"), "text/css"))
+	is.Equal(rec.Header().Get("Cache-Control"), immutableCacheControl)
+	etag := rec.Header().Get("ETag")
+	is.True(len(etag) > 0)
+
+	rec = serveStatic(&env, "/tmpl/css/tws-style.css", nil)
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Header().Get("Cache-Control"), revalidateCacheControl)
+	is.Equal(rec.Header().Get("ETag"), etag)
+	is.True(len(rec.Header().Get("Last-Modified")) > 0)
+
+	rec = serveStatic(&env, "/tmpl/css/tws-style.css", http.Header{"If-None-Match": {etag}})
+	is.Equal(rec.Code, http.StatusNotModified)
+
+	rec = serveStatic(&env, "/img/icons/heart.png", nil)
+	is.Equal(rec.Header().Get("Content-Type"), "image/png")
+	is.Equal(rec.Header().Get("Last-Modified"), "Fri, 24 Dec 2021 00:00:00 GMT")
+	rec = serveStatic(&env, "/img/icons/heart.png", http.Header{"If-Modified-Since": {"Fri, 24 Dec 2021 00:00:00 GMT"}})
+	is.Equal(rec.Code, http.StatusNotModified)
+
+	for _, path := range []string{"/frontend/js/missing.js", "/tmpl/edit.html", "/frontend/js"} {
+		rec = serveStatic(&env, path, nil)
+		is.Equal(rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestStaticAssetsFromDirectory(t *testing.T) {
+	is := is.New(t)
+	static, err := newStaticAssets(testAssets, false)
+	is.NoErr(err)
+	env := environment{db: &stubDB{}, static: static}
+
+	// the files are read on every request, so the URLs stay stable while editing them
+	is.Equal(static.url("frontend/js/main.js"), "/frontend/js/main.js")
+	rec := serveStatic(&env, "/frontend/js/main.js", nil)
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Body.String(), "console.log(1)")
+	is.Equal(rec.Header().Get("Cache-Control"), revalidateCacheControl)
+	is.Equal(serveStatic(&env, "/frontend/js", nil).Code, http.StatusNotFound)
+	is.Equal(serveStatic(&env, "/tmpl/edit.html", nil).Code, http.StatusNotFound)
+}
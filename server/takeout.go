@@ -0,0 +1,373 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	"tinywebserver/i18n"
+	"tinywebserver/session"
+)
+
+const (
+	// takeoutLifetime is how long the archive can be downloaded once it's built
+	takeoutLifetime = time.Hour
+	// maxTakeoutBuilds limits the archives built at the same time, every build reads all of the posts
+	maxTakeoutBuilds = 2
+	// takeoutDir is the directory the archive unpacks into
+	takeoutDir = "tws-takeout/"
+)
+
+type takeoutStatus string
+
+const (
+	takeoutPending takeoutStatus = "pending"
+	takeoutReady   takeoutStatus = "ready"
+	takeoutFailed  takeoutStatus = "failed"
+)
+
+// takeoutJob is the archive of the user's data built in the background. The token is a part of the
+// download link, so the link of an older archive stops working once a new one is requested
+type takeoutJob struct {
+	Token     string
+	Status    takeoutStatus
+	CreatedAt time.Time
+	// ExpiresAt is set once the build is finished
+	ExpiresAt time.Time
+	archive   []byte
+	// done is closed once the build is finished
+	done chan struct{}
+}
+
+// URL is the download link of the archive
+func (job takeoutJob) URL() string {
+	return "/settings/export/" + job.Token
+}
+
+// takeoutJobs keeps the latest archive of every user in memory until it expires
+type takeoutJobs struct {
+	mu     sync.Mutex
+	jobs   map[string]*takeoutJob
+	builds chan struct{}
+	now    func() time.Time
+}
+
+func newTakeoutJobs() *takeoutJobs {
+	return &takeoutJobs{
+		jobs:   map[string]*takeoutJob{},
+		builds: make(chan struct{}, maxTakeoutBuilds),
+		now:    time.Now,
+	}
+}
+
+// start builds the archive of the user in the background, replacing the previous one. If a build
+// of the user is already running, it's returned and no other one is started
+func (jobs *takeoutJobs) start(userID string, build func(now time.Time) ([]byte, error)) (takeoutJob, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.prune()
+	if job, ok := jobs.jobs[userID]; ok && job.Status == takeoutPending {
+		return *job, false
+	}
+	job := &takeoutJob{
+		Token:     newSecureToken(),
+		Status:    takeoutPending,
+		CreatedAt: jobs.now(),
+		done:      make(chan struct{}),
+	}
+	jobs.jobs[userID] = job
+	go jobs.run(userID, job, build)
+	return *job, true
+}
+
+func (jobs *takeoutJobs) run(userID string, job *takeoutJob, build func(now time.Time) ([]byte, error)) {
+	jobs.builds <- struct{}{}
+	archive, err := build(job.CreatedAt)
+	<-jobs.builds
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	if err != nil {
+		job.Status = takeoutFailed
+		serverLog.Error("takeout failed", "user_id", userID, "err", err)
+	} else {
+		job.Status, job.archive = takeoutReady, archive
+		serverLog.Info("takeout built", "user_id", userID, "bytes", len(archive))
+	}
+	job.ExpiresAt = jobs.now().Add(takeoutLifetime)
+	close(job.done)
+	// the archive isn't kept in memory until the next request of any user
+	time.AfterFunc(takeoutLifetime, func() {
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		jobs.prune()
+	})
+}
+
+// get returns the latest job of the user unless it has expired
+func (jobs *takeoutJobs) get(userID string) (takeoutJob, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.prune()
+	job, ok := jobs.jobs[userID]
+	if !ok {
+		return takeoutJob{}, false
+	}
+	return *job, true
+}
+
+// drop forgets the archive of the user, e.g. once the account is deleted. A running build finishes,
+// but its archive can't be downloaded
+func (jobs *takeoutJobs) drop(userID string) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	delete(jobs.jobs, userID)
+}
+
+// prune drops the expired jobs, the caller holds the lock
+func (jobs *takeoutJobs) prune() {
+	now := jobs.now()
+	for userID, job := range jobs.jobs {
+		if job.Status != takeoutPending && !now.Before(job.ExpiresAt) {
+			delete(jobs.jobs, userID)
+		}
+	}
+}
+
+type takeoutProfile struct {
+	ID         string `json:"id"`
+	AvatarURL  string `json:"avatar_url"`
+	Admin      bool   `json:"admin"`
+	Locale     string `json:"locale,omitempty"`
+	ExportedAt string `json:"exported_at"`
+}
+
+// takeoutPost is a post of the archive, the reposts and the quotes carry the reposted post
+type takeoutPost struct {
+	ID        int          `json:"id"`
+	Type      string       `json:"type"`
+	AuthorID  string       `json:"author_id"`
+	Text      string       `json:"text"`
+	CreatedAt string       `json:"created_at"`
+	Likes     int          `json:"likes"`
+	Reposted  *takeoutPost `json:"reposted,omitempty"`
+}
+
+func newTakeoutPost(p dbPost) takeoutPost {
+	return takeoutPost{
+		ID:        p.postId,
+		Type:      postTypeLabel(figureOutDbPostType(&p)),
+		AuthorID:  string(p.CreatorId),
+		Text:      p.Text,
+		CreatedAt: string(p.CreationDate),
+		Likes:     p.LikesCount,
+	}
+}
+
+// takeoutArchive is the data of the user put into the archive, it's the view model of takeout.html
+type takeoutArchive struct {
+	Profile    takeoutProfile
+	Posts      []takeoutPost
+	Liked      []takeoutPost
+	Locale     *i18n.Locale
+	exportedAt time.Time
+}
+
+func (archive takeoutArchive) T(key string, args ...interface{}) string {
+	return archive.Locale.T(key, args...)
+}
+
+func (archive takeoutArchive) ExportedAt() string {
+	return archive.Locale.FormatTime(archive.exportedAt)
+}
+
+// Date formats the creation date of the post for the locale, it's empty when the date is unknown
+func (archive takeoutArchive) Date(post takeoutPost) string {
+	created, err := time.Parse(twsTimeFormat, post.CreatedAt)
+	if err != nil {
+		return ""
+	}
+	return archive.Locale.FormatTime(created)
+}
+
+// takeoutPostView is the view model of the takeout_post template, e.g. << takeoutPost $ . >>
+type takeoutPostView struct {
+	Archive takeoutArchive
+	Post    takeoutPost
+}
+
+func newTakeoutPostView(archive takeoutArchive, post takeoutPost) takeoutPostView {
+	return takeoutPostView{Archive: archive, Post: post}
+}
+
+// collectTakeout reads the profile of the user, their posts and quotes and the posts they liked,
+// the newest first
+func collectTakeout(db iDB, userID string, locale *i18n.Locale, now time.Time) (takeoutArchive, error) {
+	user, err := db.getUser(userID)
+	if err != nil {
+		return takeoutArchive{}, fmt.Errorf("couldn't load user %v: %w", userID, err)
+	}
+	archive := takeoutArchive{
+		Profile: takeoutProfile{
+			ID:         userID,
+			AvatarURL:  user.AvatarUrl,
+			Admin:      user.AdminRight == ADMIN,
+			Locale:     user.Locale,
+			ExportedAt: now.UTC().Format(twsTimeFormat),
+		},
+		Posts:      []takeoutPost{},
+		Liked:      []takeoutPost{},
+		Locale:     locale,
+		exportedAt: now,
+	}
+
+	postIDs, err := db.getUserPostIDs(userID)
+	if err != nil {
+		return takeoutArchive{}, fmt.Errorf("couldn't load posts: %w", err)
+	}
+	posts, err := db.getLatestUserPosts([]byte(userID), len(postIDs), 0)
+	if err != nil {
+		return takeoutArchive{}, fmt.Errorf("couldn't load posts: %w", err)
+	}
+	for _, p := range posts {
+		post := newTakeoutPost(p)
+		if p.RepostId > 0 {
+			// the reposted post may be deleted by now, the repost is kept anyway
+			if reposted, err := db.getUserPost(p.RepostId); err == nil {
+				repostedPost := newTakeoutPost(reposted)
+				post.Reposted = &repostedPost
+			}
+		}
+		archive.Posts = append(archive.Posts, post)
+	}
+
+	liked, err := db.getLikedPosts(userID)
+	if err != nil {
+		return takeoutArchive{}, fmt.Errorf("couldn't load liked posts: %w", err)
+	}
+	for i := len(liked) - 1; i >= 0; i-- {
+		archive.Liked = append(archive.Liked, newTakeoutPost(liked[i]))
+	}
+	return archive, nil
+}
+
+// writeTakeout packs the data as JSON files along with index.html, which shows them without
+// the server or an internet connection
+func writeTakeout(archive takeoutArchive) ([]byte, error) {
+	var page bytes.Buffer
+	if err := templates.execute(&page, "takeout.html", archive); err != nil {
+		return nil, fmt.Errorf("couldn't render the archive page: %w", err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, content []byte) error {
+		header := &zip.FileHeader{Name: takeoutDir + name, Method: zip.Deflate, Modified: archive.exportedAt}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	}
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", archive.Profile},
+		{"posts.json", archive.Posts},
+		{"liked_posts.json", archive.Liked},
+	}
+	for _, file := range files {
+		content, err := json.MarshalIndent(file.data, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := add(file.name, content); err != nil {
+			return nil, err
+		}
+	}
+	if err := add("index.html", page.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// TakeoutPage is the view model of settings_export.html, Job is nil until the user asks for an archive
+type TakeoutPage struct {
+	Job *takeoutJob
+}
+
+// Pending reports whether the archive is being built, the page reloads itself meanwhile
+func (page TakeoutPage) Pending() bool {
+	return page.Job != nil && page.Job.Status == takeoutPending
+}
+
+func (env *environment) takeoutPageHandler(w http.ResponseWriter, r *http.Request) {
+	userData, err := env.readUserData(r)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	page := TakeoutPage{}
+	if job, ok := env.takeouts.get(userData.Id); ok {
+		page.Job = &job
+	}
+	env.render(w, r, "settings_export.html", page)
+}
+
+// startTakeoutHandler starts building the archive, the user is sent back to the settings page,
+// which shows the download link once it's ready
+func (env *environment) startTakeoutHandler(w http.ResponseWriter, r *http.Request) {
+	userData, err := env.readUserData(r)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	userSession, _ := env.sessionManager.ReadSession(r)
+	locale := requestLocale(r, userSession)
+	// the build outlives the request, so it doesn't use the request database
+	db := env.db
+	_, started := env.takeouts.start(userData.Id, func(now time.Time) ([]byte, error) {
+		archive, err := collectTakeout(db, userData.Id, locale, now)
+		if err != nil {
+			return nil, err
+		}
+		return writeTakeout(archive)
+	})
+	if started {
+		requestLog(r).Info("takeout started", "user_id", userData.Id)
+		env.addFlash(r, session.FlashSuccess, "flash.takeout_started")
+	} else {
+		env.addFlash(r, session.FlashWarning, "flash.takeout_running")
+	}
+	http.Redirect(w, r, "/settings/export/", http.StatusFound)
+}
+
+// downloadTakeoutHandler serves the archive to its owner until it expires
+func (env *environment) downloadTakeoutHandler(w http.ResponseWriter, r *http.Request) {
+	userData, err := env.readUserData(r)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	job, ok := env.takeouts.get(userData.Id)
+	token := pathParam(r, "token")
+	if !ok || job.Status != takeoutReady || subtle.ConstantTimeCompare([]byte(job.Token), []byte(token)) != 1 {
+		env.renderError(w, r, http.StatusNotFound, fmt.Errorf("no takeout of user %v with the token", userData.Id))
+		return
+	}
+	fileName := "tws-takeout-" + job.CreatedAt.UTC().Format(backupTimeFormat) + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(job.archive)
+	requestLog(r).Info("takeout downloaded", "bytes", len(job.archive))
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/matryer/is"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"tinywebserver/session"
+	"tinywebserver/utils"
+)
+
+func readZipFile(is *is.I, archive *zip.Reader, name string) string {
+	file, err := archive.Open(name)
+	is.NoErr(err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	is.NoErr(err)
+	return string(content)
+}
+
+func TestTakeoutArchive(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	createTestUser(is, db, "alice", "alice.png")
+	createTestUser(is, db, "bob", "bob.png")
+	postID, err := db.saveUserPost([]byte("alice"), "hello <alice>")
+	is.NoErr(err)
+	quoteID, err := db.repostUserPost(utils.Itob(postID), []byte("bob"), "quoting")
+	is.NoErr(err)
+	is.NoErr(db.toggleLikeOnUserPost([]byte("bob"), quoteID, "alice"))
+
+	now := time.Date(2022, time.March, 8, 10, 4, 0, 0, time.UTC)
+	archive, err := collectTakeout(db, "bob", translations.Locale("en"), now)
+	is.NoErr(err)
+	is.Equal(len(archive.Posts), 1)
+	is.Equal(archive.Posts[0].Type, "quote")
+	is.Equal(archive.Posts[0].Likes, 1)
+	is.Equal(archive.Posts[0].Reposted.Text, "hello <alice>")
+	is.Equal(len(archive.Liked), 0)
+
+	archive, err = collectTakeout(db, "alice", translations.Locale("en"), now)
+	is.NoErr(err)
+	content, err := writeTakeout(archive)
+	is.NoErr(err)
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	is.NoErr(err)
+	var names []string
+	for _, file := range zr.File {
+		names = append(names, file.Name)
+	}
+	is.Equal(names, []string{"tws-takeout/profile.json", "tws-takeout/posts.json", "tws-takeout/liked_posts.json", "tws-takeout/index.html"})
+
+	var profile takeoutProfile
+	is.NoErr(json.Unmarshal([]byte(readZipFile(is, zr, "tws-takeout/profile.json")), &profile))
+	is.Equal(profile, takeoutProfile{ID: "alice", AvatarURL: "alice.png", ExportedAt: "2022-03-08T10:04:00.000Z"})
+	var liked []takeoutPost
+	is.NoErr(json.Unmarshal([]byte(readZipFile(is, zr, "tws-takeout/liked_posts.json")), &liked))
+	is.Equal(len(liked), 1)
+	is.Equal(liked[0].ID, quoteID)
+	is.Equal(liked[0].AuthorID, "bob")
+
+	page := readZipFile(is, zr, "tws-takeout/index.html")
+	is.True(strings.HasPrefix(page, "<!DOCTYPE html>"))
+	is.True(strings.Contains(page, "<title>Data of alice</title>"))
+	is.True(strings.Contains(page, "hello &lt;alice&gt;"))
+	is.True(strings.Contains(page, "Exported on Mar 8, 2022 at 10:04 UTC"))
+	// the page is browsed offline, so nothing is loaded from the server
+	is.True(!strings.Contains(page, `src="/`) && !strings.Contains(page, `href="/`))
+}
+
+func TestTakeoutHandlers(t *testing.T) {
+	is := is.New(t)
+	env := environment{db: &stubDB{}, sessionManager: session.NewManager("memory", "twssessionid", 3600), takeouts: newTakeoutJobs()}
+	mux := env.routes()
+	serve := func(method string, path string, userData TwsUserData) *httptest.ResponseRecorder {
+		form := url.Values{"csrf_token": {testCSRFToken}}
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		loginTestUser(&env, req, userData)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/settings/export/", defaultTestUserData)
+	is.Equal(rec.Code, http.StatusOK)
+	is.True(strings.Contains(rec.Body.String(), `action="/settings/export/"`))
+
+	checkIfRedirect(serve(http.MethodPost, "/settings/export/", defaultTestUserData), "/settings/export/", t)
+	job, ok := env.takeouts.get(defaultTestUserData.Id)
+	is.True(ok)
+	<-job.done
+	job, _ = env.takeouts.get(defaultTestUserData.Id)
+	is.Equal(job.Status, takeoutReady)
+
+	rec = serve(http.MethodGet, "/settings/export/", defaultTestUserData)
+	is.True(strings.Contains(rec.Body.String(), `href="`+job.URL()+`"`))
+	rec = serve(http.MethodGet, job.URL(), defaultTestUserData)
+	is.Equal(rec.Code, http.StatusOK)
+	is.Equal(rec.Header().Get("Content-Type"), "application/zip")
+	is.Equal(rec.Header().Get("Cache-Control"), "no-store")
+	is.True(bytes.Equal(rec.Body.Bytes(), job.archive))
+
+	// the link works only for its owner and only until it expires
+	otherUser := defaultTestUserData
+	otherUser.Id = "other"
+	is.Equal(serve(http.MethodGet, job.URL(), otherUser).Code, http.StatusNotFound)
+	is.Equal(serve(http.MethodGet, "/settings/export/forged", defaultTestUserData).Code, http.StatusNotFound)
+	env.takeouts.now = func() time.Time { return job.ExpiresAt }
+	is.Equal(serve(http.MethodGet, job.URL(), defaultTestUserData).Code, http.StatusNotFound)
+}
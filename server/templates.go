@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sharedTemplateDirs hold the layouts and the partials, every page can use them. The pages are parsed
+// separately, so each of them can fill the blocks of the layout, e.g. "content", in its own way
+var sharedTemplateDirs = []string{"layouts", "partials"}
+
+// templateSet holds all of the *.html templates found under the directory, the pages are referred to by
+// their file names, e.g. "edit.html". With reload on, the directory is checked on every use and the
+// templates are parsed again once a file is added, removed or modified
+type templateSet struct {
+	fsys   fs.FS
+	dir    string
+	funcs  template.FuncMap
+	reload bool
+
+	mu      sync.Mutex
+	pages   map[string]*template.Template
+	files   map[string]string
+	modTime map[string]time.Time
+}
+
+// templateFuncs are available in all of the templates, e.g. << asset "tmpl/css/tws-style.css" >>
+func templateFuncs(static *staticAssets) template.FuncMap {
+	return template.FuncMap{
+		"asset":       static.url,
+		"postCard":    newPostCard,
+		"takeoutPost": newTakeoutPostView,
+	}
+}
+
+func newTemplateSet(fsys fs.FS, dir string, funcs template.FuncMap, reload bool) (*templateSet, error) {
+	set := &templateSet{fsys: fsys, dir: path.Clean(dir), funcs: funcs, reload: reload}
+	modTime, err := set.scan()
+	if err != nil {
+		return nil, err
+	}
+	if err := set.parse(modTime); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// scan finds the templates and their modification times
+func (set *templateSet) scan() (map[string]time.Time, error) {
+	modTime := map[string]time.Time{}
+	err := fs.WalkDir(set.fsys, set.dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || path.Ext(name) != ".html" {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		modTime[name] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't find templates in %v: %w", set.dir, err)
+	}
+	if len(modTime) == 0 {
+		return nil, fmt.Errorf("no templates found in %v", set.dir)
+	}
+	return modTime, nil
+}
+
+func (set *templateSet) isShared(name string) bool {
+	for _, dir := range sharedTemplateDirs {
+		if strings.HasPrefix(name, path.Join(set.dir, dir)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (set *templateSet) parse(modTime map[string]time.Time) error {
+	files := map[string]string{}
+	var shared, pageFiles []string
+	for name := range modTime {
+		base := path.Base(name)
+		if other, ok := files[base]; ok {
+			return fmt.Errorf("templates %v and %v have the same name", other, name)
+		}
+		files[base] = name
+		if set.isShared(name) {
+			shared = append(shared, name)
+		} else {
+			pageFiles = append(pageFiles, name)
+		}
+	}
+	sort.Strings(shared)
+	sort.Strings(pageFiles)
+
+	base := template.New("tmpl").Delims("<<", ">>").Funcs(set.funcs)
+	if len(shared) > 0 {
+		if _, err := base.ParseFS(set.fsys, shared...); err != nil {
+			return &templateError{err: err, files: files}
+		}
+	}
+	pages := map[string]*template.Template{}
+	for _, name := range pageFiles {
+		page, err := base.Clone()
+		if err == nil {
+			page, err = page.ParseFS(set.fsys, name)
+		}
+		if err != nil {
+			return &templateError{err: err, files: files}
+		}
+		pages[path.Base(name)] = page
+	}
+	set.pages, set.files, set.modTime = pages, files, modTime
+	return nil
+}
+
+// get returns the parsed pages, re-parsing them first if they changed on the disk
+func (set *templateSet) get() (map[string]*template.Template, error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if !set.reload {
+		return set.pages, nil
+	}
+	modTime, err := set.scan()
+	if err != nil {
+		return nil, err
+	}
+	if sameModTimes(modTime, set.modTime) {
+		return set.pages, nil
+	}
+	if err := set.parse(modTime); err != nil {
+		// the next request tries again, the broken templates are parsed until they are fixed
+		set.modTime = nil
+		return nil, err
+	}
+	serverLog.Info("templates reloaded", "count", len(modTime))
+	return set.pages, nil
+}
+
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, modTime := range a {
+		if other, ok := b[name]; !ok || !other.Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// execute renders the template into buf, the errors carry the template files for the error page
+func (set *templateSet) execute(buf *bytes.Buffer, name string, data interface{}) error {
+	pages, err := set.get()
+	if err != nil {
+		return err
+	}
+	page, ok := pages[name]
+	if !ok {
+		return fmt.Errorf("template %v doesn't exist", name)
+	}
+	if err := page.ExecuteTemplate(buf, name, data); err != nil {
+		set.mu.Lock()
+		defer set.mu.Unlock()
+		return &templateError{err: err, files: set.files}
+	}
+	return nil
+}
+
+// templateError is a parsing or execution error of the templates. The messages of html/template
+// start with the location, e.g. "template: edit.html:12:5: ..."
+type templateError struct {
+	err   error
+	files map[string]string
+}
+
+func (e *templateError) Error() string {
+	return e.err.Error()
+}
+
+func (e *templateError) Unwrap() error {
+	return e.err
+}
+
+var templateLocation = regexp.MustCompile(`template: ([^:\s]+):(\d+)`)
+
+// location returns the template file and the line the error points to
+func (e *templateError) location() (file string, line int, ok bool) {
+	match := templateLocation.FindStringSubmatch(e.err.Error())
+	if match == nil {
+		return "", 0, false
+	}
+	file, ok = e.files[match[1]]
+	line, _ = strconv.Atoi(match[2])
+	return file, line, ok && line > 0
+}
+
+type sourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+type templateErrorPage struct {
+	Message string
+	File    string
+	Line    int
+	Source  []sourceLine
+}
+
+// the page doesn't depend on the templates of the site, they may be the broken ones
+var templateErrorTemplate = template.Must(template.New("template_error").Parse(`<!DOCTYPE html>
+<html>
+    <head>
+        <meta charset="utf-8">
+        <title>Template error</title>
+    </head>
+    <body>
+        <h1>Template error</h1>
+        <pre>{{ .Message }}</pre>
+        {{ if .File }}
+        <h2>{{ .File }}:{{ .Line }}</h2>
+        <pre>{{ range .Source }}{{ if .Current }}<mark>{{ end }}{{ printf "%4d" .Number }}  {{ .Text }}{{ if .Current }}</mark>{{ end }}
+{{ end }}</pre>
+        {{ end }}
+    </body>
+</html>
+`))
+
+const templateErrorContextLines = 5
+
+// renderTemplateError shows the template error together with the lines around it, it's used only in
+// the development mode as it reveals the sources
+func (set *templateSet) renderTemplateError(w http.ResponseWriter, err error) {
+	page := templateErrorPage{Message: err.Error()}
+	if tmplErr, ok := err.(*templateError); ok {
+		if file, line, ok := tmplErr.location(); ok {
+			page.File, page.Line = file, line
+			if content, err := fs.ReadFile(set.fsys, file); err == nil {
+				lines := strings.Split(string(content), "\n")
+				for i := line - templateErrorContextLines; i <= line+templateErrorContextLines; i++ {
+					if i >= 1 && i <= len(lines) {
+						page.Source = append(page.Source, sourceLine{Number: i, Text: lines[i-1], Current: i == line})
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if templateErrorTemplate.Execute(&buf, page) != nil {
+		http.Error(w, page.Message, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(buf.Bytes())
+}
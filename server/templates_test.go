@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTemplate(t *testing.T, dir, name, content string, modTime time.Time) {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the modification times are set explicitly, the writes may happen within the file system time resolution
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func executeToString(set *templateSet, name string) (string, error) {
+	var buf bytes.Buffer
+	err := set.execute(&buf, name, nil)
+	return buf.String(), err
+}
+
+func TestTemplateSetDiscovery(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	writeTemplate(t, dir, "tmpl/page.html", `<< template "header" >>page`, modTime)
+	writeTemplate(t, dir, "tmpl/partials/header.html", `<< define "header" >>header,<< end >>`, modTime)
+	writeTemplate(t, dir, "tmpl/css/style.css", `body {}`, modTime)
+
+	set, err := newTemplateSet(os.DirFS(dir), "tmpl/", nil, false)
+	is.NoErr(err)
+	out, err := executeToString(set, "page.html")
+	is.NoErr(err)
+	is.Equal(out, "header,page")
+
+	// without reload the changes are ignored
+	writeTemplate(t, dir, "tmpl/page.html", `changed`, modTime.Add(time.Minute))
+	out, err = executeToString(set, "page.html")
+	is.NoErr(err)
+	is.Equal(out, "header,page")
+
+	writeTemplate(t, dir, "tmpl/other/page.html", `duplicate`, modTime)
+	_, err = newTemplateSet(os.DirFS(dir), "tmpl", nil, false)
+	is.True(err != nil)
+
+	_, err = newTemplateSet(os.DirFS(dir), "missing", nil, false)
+	is.True(err != nil)
+}
+
+func TestTemplateSetReload(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	writeTemplate(t, dir, "tmpl/page.html", `first`, modTime)
+
+	set, err := newTemplateSet(os.DirFS(dir), "tmpl", nil, true)
+	is.NoErr(err)
+	out, err := executeToString(set, "page.html")
+	is.NoErr(err)
+	is.Equal(out, "first")
+
+	writeTemplate(t, dir, "tmpl/page.html", `second`, modTime.Add(time.Minute))
+	writeTemplate(t, dir, "tmpl/new.html", `new`, modTime)
+	out, err = executeToString(set, "page.html")
+	is.NoErr(err)
+	is.Equal(out, "second")
+	out, err = executeToString(set, "new.html")
+	is.NoErr(err)
+	is.Equal(out, "new")
+
+	writeTemplate(t, dir, "tmpl/page.html", "line 1\nline 2\n<< if >>\nline 4", modTime.Add(2*time.Minute))
+	_, err = executeToString(set, "page.html")
+	tmplErr, ok := err.(*templateError)
+	is.True(ok)
+	file, line, ok := tmplErr.location()
+	is.True(ok)
+	is.Equal(file, "tmpl/page.html")
+	is.Equal(line, 3)
+
+	// the broken template keeps failing until it's fixed
+	_, err = executeToString(set, "new.html")
+	is.True(err != nil)
+	writeTemplate(t, dir, "tmpl/page.html", `fixed`, modTime.Add(3*time.Minute))
+	out, err = executeToString(set, "page.html")
+	is.NoErr(err)
+	is.Equal(out, "fixed")
+}
+
+func TestTemplateErrorPage(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	writeTemplate(t, dir, "tmpl/page.html", "<p>\n<< .Missing.Field >>\n</p>", modTime)
+	set, err := newTemplateSet(os.DirFS(dir), "tmpl", nil, true)
+	is.NoErr(err)
+
+	previous := templates
+	templates = set
+	defer func() { templates = previous }()
+
+	env := environment{db: &stubDB{}}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	env.executeTemplate(rec, req, "page.html", struct{ Missing *struct{ Field string } }{})
+	is.Equal(rec.Code, http.StatusInternalServerError)
+	body := rec.Body.String()
+	is.True(strings.Contains(body, "tmpl/page.html:2"))
+	is.True(strings.Contains(body, "<mark>   2  &lt;&lt; .Missing.Field &gt;&gt;</mark>"))
+	is.True(strings.Contains(body, "   1  &lt;p&gt;"))
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"net/http"
+	"net/url"
+	"time"
+	"tinywebserver/utils"
+)
+
+// cPostsByTimeBucket indexes the posts by their creation time, the keys are postTimeKey and the
+// values are empty
+const cPostsByTimeBucket = "PostsByTime"
+
+const (
+	explorePageSize = 20
+	// exploreDateFormat is the format of the from and to parameters of /explore
+	exploreDateFormat = "2006-01-02"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// postTimeKey is the creation time in milliseconds followed by the post ID, so the posts created
+// in the same millisecond keep the order of their IDs
+func postTimeKey(created time.Time, postID int) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(created.UnixNano()/int64(time.Millisecond)))
+	copy(key[8:], utils.Itob(postID))
+	return key
+}
+
+func postCreationTime(post dbPost) (time.Time, error) {
+	created, err := time.Parse(twsTimeFormat, string(post.CreationDate))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid creation date of the post: %w", err)
+	}
+	return created, nil
+}
+
+// indexPost adds the post to the time index, it's called in the transaction which stores the post
+func indexPost(tx *bolt.Tx, postID int, post dbPost) error {
+	bucket := getBucket(tx, cPostsByTimeBucket)
+	if bucket == nil {
+		return fmt.Errorf(cPostsByTimeBucket + " bucket doesn't exist")
+	}
+	created, err := postCreationTime(post)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(postTimeKey(created, postID), []byte{})
+}
+
+// unindexPost removes the post from the time index, it's called in the transaction which deletes the post.
+// Only -fsck deletes the posts of the databases which aren't migrated yet and have no index
+func unindexPost(tx *bolt.Tx, postID int, post dbPost) error {
+	bucket := getBucket(tx, cPostsByTimeBucket)
+	if bucket == nil {
+		return nil
+	}
+	created, err := postCreationTime(post)
+	if err != nil {
+		return err
+	}
+	return bucket.Delete(postTimeKey(created, postID))
+}
+
+// indexPostsByTime is the migration which creates the time index of the existing posts
+func indexPostsByTime(tx *bolt.Tx) error {
+	if _, err := tx.CreateBucketIfNotExists([]byte(cPostsByTimeBucket)); err != nil {
+		return fmt.Errorf("couldn't create %v bucket: %w", cPostsByTimeBucket, err)
+	}
+	return getBucket(tx, cPostsBucket).ForEach(func(k, v []byte) error {
+		post := dbPost{}
+		if err := json.Unmarshal(v, &post); err != nil {
+			return fmt.Errorf("post %x: %w", k, err)
+		}
+		if err := indexPost(tx, utils.Btoi(k), post); err != nil {
+			return fmt.Errorf("post %v: %w", utils.Btoi(k), err)
+		}
+		return nil
+	})
+}
+
+// postsQuery selects the posts created in [From, To), the newest first. The zero From and To leave
+// the range open. Cursor is the Next of the previous page
+type postsQuery struct {
+	From   time.Time
+	To     time.Time
+	Cursor string
+	Limit  int
+}
+
+func encodePostsCursor(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+func decodePostsCursor(cursor string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(key) != 16 {
+		return nil, errInvalidCursor
+	}
+	return key, nil
+}
+
+// getPostsByTime returns a page of the posts of the query, next is the cursor of the following page
+// and it's empty on the last one
+func (db *twsDB) getPostsByTime(query postsQuery) (posts []dbPost, next string, err error) {
+	var upper, lower []byte
+	if len(query.Cursor) > 0 {
+		if upper, err = decodePostsCursor(query.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	if !query.To.IsZero() {
+		if toKey := postTimeKey(query.To, 0); upper == nil || bytes.Compare(toKey, upper) < 0 {
+			upper = toKey
+		}
+	}
+	if !query.From.IsZero() {
+		lower = postTimeKey(query.From, 0)
+	}
+
+	err = db.view("getPostsByTime", func(tx *bolt.Tx) error {
+		indexBucket, postsBucket := getBucket(tx, cPostsByTimeBucket), getBucket(tx, cPostsBucket)
+		if indexBucket == nil || postsBucket == nil {
+			return fmt.Errorf("the database isn't initialized")
+		}
+		cursor := indexBucket.Cursor()
+		var key, lastKey []byte
+		if upper == nil {
+			key, _ = cursor.Last()
+		} else if key, _ = cursor.Seek(upper); key == nil {
+			key, _ = cursor.Last()
+		} else {
+			key, _ = cursor.Prev()
+		}
+
+		for ; key != nil && bytes.Compare(key, lower) >= 0; key, _ = cursor.Prev() {
+			if len(posts) == query.Limit {
+				next = encodePostsCursor(lastKey)
+				return nil
+			}
+			postID := utils.Btoi(key[8:])
+			buf := postsBucket.Get(utils.Itob(postID))
+			if buf == nil {
+				db.logger().Warn("indexed post is missing from posts bucket, -fsck repairs it", "post_id", postID)
+				continue
+			}
+			post := dbPost{postId: postID}
+			if err := json.Unmarshal(buf, &post); err != nil {
+				return err
+			}
+			posts = append(posts, post)
+			lastKey = append(lastKey[:0], key...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	db.logger().Debug("loaded posts by time", "from", query.From, "to", query.To, "posts", len(posts))
+	return posts, next, nil
+}
+
+// ExplorePage is the view model of the public feed of all of the posts
+type ExplorePage struct {
+	Posts []twsPost
+	From  string
+	To    string
+	Next  string
+}
+
+// NextURL keeps the date range of the page on the link to the following one
+func (page ExplorePage) NextURL() string {
+	query := url.Values{"cursor": {page.Next}}
+	if len(page.From) > 0 {
+		query.Set("from", page.From)
+	}
+	if len(page.To) > 0 {
+		query.Set("to", page.To)
+	}
+	return "/explore/?" + query.Encode()
+}
+
+// parsePostsQuery reads the date range and the cursor of /explore, the to date is included
+func parsePostsQuery(r *http.Request) (query postsQuery, err error) {
+	query.Limit = explorePageSize
+	query.Cursor = r.URL.Query().Get("cursor")
+	if from := r.URL.Query().Get("from"); len(from) > 0 {
+		if query.From, err = time.Parse(exploreDateFormat, from); err != nil {
+			return query, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", from)
+		}
+	}
+	if to := r.URL.Query().Get("to"); len(to) > 0 {
+		if query.To, err = time.Parse(exploreDateFormat, to); err != nil {
+			return query, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", to)
+		}
+		query.To = query.To.AddDate(0, 0, 1)
+	}
+	return query, nil
+}
+
+// exploreHandler shows the posts of all of the users, the newest first. It's public, the anonymous
+// visitors see the posts without the actions
+func (env *environment) exploreHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := parsePostsQuery(r)
+	if err != nil {
+		env.renderError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	db := env.requestDB(r)
+	posts, next, err := db.getPostsByTime(query)
+	if errors.Is(err, errInvalidCursor) {
+		env.renderError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	page := ExplorePage{From: r.URL.Query().Get("from"), To: r.URL.Query().Get("to"), Next: next}
+	avatars := map[string]string{}
+	for i := range posts {
+		post, err := newFeedPost(db, &posts[i], avatars)
+		if err != nil {
+			requestLog(r).Warn("couldn't load post", "post_id", posts[i].postId, "err", err)
+			continue
+		}
+		page.Posts = append(page.Posts, post)
+	}
+	env.markLiked(r, postPointers(page.Posts)...)
+	env.render(w, r, "explore.html", page)
+}
+
+// newFeedPost converts the post of a feed with posts of many users, avatars caches the avatars of
+// the creators already loaded
+func newFeedPost(db iDB, p *dbPost, avatars map[string]string) (twsPost, error) {
+	post := twsPost{OwnerId: string(p.CreatorId), OwnerName: string(p.CreatorId), Type: figureOutDbPostType(p)}
+	if err := post.convertFromDBPost(p); err != nil {
+		return post, err
+	}
+	if len(post.OwnerId) > 0 {
+		avatar, ok := avatars[post.OwnerId]
+		if !ok {
+			user, err := db.getUser(post.OwnerId)
+			if err != nil {
+				return post, err
+			}
+			avatar = user.AvatarUrl
+			avatars[post.OwnerId] = avatar
+		}
+		post.OwnerAvatar = avatar
+	}
+	if post.Type != PostType_Post {
+		repost, err := loadRepostedPost(db, p.RepostId)
+		if err != nil {
+			return post, err
+		}
+		post.Repost = repost
+	}
+	return post, nil
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"tinywebserver/session"
+	"tinywebserver/utils"
+)
+
+// putTimedPost stores alice's post created at the time, the way saveUserPost does it
+func putTimedPost(is *is.I, db *twsDB, created time.Time, text string) int {
+	var postID int
+	is.NoErr(db.db.Update(func(tx *bolt.Tx) error {
+		id, err := getBucket(tx, cPostsBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		postID = int(id)
+		post := dbPost{Text: text, CreationDate: toTwsUTCTime(created), CreatorId: []byte("alice")}
+		buf, err := json.Marshal(post)
+		if err != nil {
+			return err
+		}
+		if err := getBucket(tx, cPostsBucket).Put(utils.Itob(postID), buf); err != nil {
+			return err
+		}
+		if err := appendPostToUser(tx, post.CreatorId, postID); err != nil {
+			return err
+		}
+		return indexPost(tx, postID, post)
+	}))
+	return postID
+}
+
+func postTexts(posts []dbPost) []string {
+	texts := []string{}
+	for _, post := range posts {
+		texts = append(texts, post.Text)
+	}
+	return texts
+}
+
+func TestGetPostsByTime(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	createTestUser(is, db, "alice", "alice.png")
+	day := time.Date(2022, time.March, 8, 10, 4, 0, 0, time.UTC)
+	putTimedPost(is, db, day.AddDate(0, 0, 1), "march 9")
+	putTimedPost(is, db, day, "march 8")
+	// the posts of the same millisecond are ordered by their IDs
+	putTimedPost(is, db, day, "march 8 again")
+	putTimedPost(is, db, day.AddDate(0, 0, -1), "march 7")
+
+	posts, next, err := db.getPostsByTime(postsQuery{Limit: 2})
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"march 9", "march 8 again"})
+	is.True(len(next) > 0)
+	posts, next, err = db.getPostsByTime(postsQuery{Limit: 2, Cursor: next})
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"march 8", "march 7"})
+	is.Equal(next, "")
+
+	march8 := time.Date(2022, time.March, 8, 0, 0, 0, 0, time.UTC)
+	posts, next, err = db.getPostsByTime(postsQuery{From: march8, To: march8.AddDate(0, 0, 1), Limit: 1})
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"march 8 again"})
+	posts, next, err = db.getPostsByTime(postsQuery{From: march8, To: march8.AddDate(0, 0, 1), Limit: 1, Cursor: next})
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"march 8"})
+	is.Equal(next, "")
+
+	_, _, err = db.getPostsByTime(postsQuery{Limit: 2, Cursor: "nope"})
+	is.Equal(err, errInvalidCursor)
+}
+
+func TestPostsIndexMaintained(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	createTestUser(is, db, "alice", "alice.png")
+	createTestUser(is, db, "bob", "bob.png")
+	postID, err := db.saveUserPost([]byte("alice"), "alice's post")
+	is.NoErr(err)
+	_, err = db.repostUserPost(utils.Itob(postID), []byte("bob"), "")
+	is.NoErr(err)
+	_, err = db.repostUserPost(utils.Itob(postID), []byte("bob"), "bob's quote")
+	is.NoErr(err)
+	posts, _, err := db.getPostsByTime(postsQuery{Limit: 10})
+	is.NoErr(err)
+	is.Equal(len(posts), 3)
+
+	// the repost and the quote of the deleted post stay in the index
+	is.NoErr(db.deleteUserPost([]byte("alice"), postID))
+	posts, _, err = db.getPostsByTime(postsQuery{Limit: 10})
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"bob's quote", ""})
+	_, err = db.deleteUser("bob", deletionDelete)
+	is.NoErr(err)
+	posts, _, err = db.getPostsByTime(postsQuery{Limit: 10})
+	is.NoErr(err)
+	is.Equal(len(posts), 0)
+}
+
+func TestIndexPostsByTimeMigration(t *testing.T) {
+	is := is.New(t)
+	db := generateTestDB(is, t)
+	_, err := migrate(db, migrations[:1], false)
+	is.NoErr(err)
+	testDB := &twsDB{db: db}
+	createTestUser(is, testDB, "alice", "alice.png")
+	is.NoErr(db.Update(func(tx *bolt.Tx) error {
+		buf, err := json.Marshal(dbPost{Text: "old post", CreationDate: []byte("2022-03-08T10:04:00.000Z"), CreatorId: []byte("alice")})
+		if err != nil {
+			return err
+		}
+		return getBucket(tx, cPostsBucket).Put(utils.Itob(1), buf)
+	}))
+
+	_, err = migrate(db, migrations, false)
+	is.NoErr(err)
+	posts, _, err := testDB.getPostsByTime(postsQuery{Limit: 10})
+	is.NoErr(err)
+	is.Equal(postTexts(posts), []string{"old post"})
+}
+
+func TestExploreHandler(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	createTestUser(is, db, "alice", "alice.png")
+	day := time.Date(2022, time.March, 8, 10, 4, 0, 0, time.UTC)
+	for i := 0; i <= explorePageSize; i++ {
+		putTimedPost(is, db, day.Add(time.Duration(i)*time.Minute), "post of march 8")
+	}
+	putTimedPost(is, db, day.AddDate(0, 0, 1), "post of march 9")
+	env := environment{db: db, sessionManager: session.NewManager("memory", "twssessionid", 3600)}
+	mux := env.routes()
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	// the feed is public, the anonymous visitors get the cards without the actions
+	rec := get("/explore/")
+	is.Equal(rec.Code, http.StatusOK)
+	body := rec.Body.String()
+	is.Equal(strings.Count(body, `class="tws-card `), explorePageSize)
+	is.True(strings.Contains(body, "post of march 9"))
+	is.True(strings.Contains(body, `src="alice.png"`))
+	is.True(!strings.Contains(body, "/like_post/"))
+	is.True(strings.Contains(body, `href="/explore/?cursor=`))
+
+	rec = get("/explore/?from=2022-03-08&to=2022-03-08")
+	is.Equal(rec.Code, http.StatusOK)
+	body = rec.Body.String()
+	is.True(!strings.Contains(body, "post of march 9"))
+	is.True(strings.Contains(body, "from=2022-03-08&amp;to=2022-03-08"))
+
+	is.Equal(get("/explore/?from=yesterday").Code, http.StatusBadRequest)
+	is.Equal(get("/explore/?cursor=nope").Code, http.StatusBadRequest)
+}
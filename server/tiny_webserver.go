@@ -2,22 +2,35 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/microcosm-cc/bluemonday"
-	"html/template"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
-	"regexp"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"tinywebserver/config"
+	"tinywebserver/i18n"
+	"tinywebserver/logger"
 	"tinywebserver/session"
 	"tinywebserver/utils"
 )
 
+// maxPostLength is the maximal length of the post text in bytes
+const maxPostLength = 240
+
 const twsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
 
 func toTwsUTCTime(time time.Time) []byte {
@@ -35,11 +48,21 @@ type iDB interface {
 	getUser(userId string) (dbUserData, error)
 	getUserPost(postID int) (post dbPost, err error)
 	getUserPosts(postsId []int) ([]dbPost, error)
+	getUserPostIDs(userID string) ([]int, error)
+	getLikedPosts(userID string) ([]dbPost, error)
 	getLatestUserPosts(ownerID []byte, maxPostsToGet int, lastKey int) (posts []dbPost, err error)
+	getPostsByTime(query postsQuery) (posts []dbPost, next string, err error)
+	getPostLikes(postID int, after string, limit int) (userIDs []string, next string, err error)
+	getUserLikes(userID string, postIDs []int) (map[int]bool, error)
 	saveUserPost(ownerID []byte, post string) (postID int, err error)
 	deleteUserPost(ownerID []byte, postID int) error
 	toggleLikeOnUserPost(ownerID []byte, postID int, likeOwner string) error
+	setUserLocale(userID []byte, locale string) error
+	deleteUser(userID string, policy deletionPolicy) (deletionStats, error)
 	repostUserPost(postToRepostId []byte, reposterId []byte, reposterText string) (resultPostId int, err error)
+	withContext(ctx context.Context) iDB
+	backup(w io.Writer) (int64, error)
+	checkHealth() error
 }
 
 type environment struct {
@@ -47,6 +70,19 @@ type environment struct {
 	oauth          iOauth
 	sessionManager *session.Manager
 	sanitizer      *bluemonday.Policy
+	debug          bool
+	tls            config.TLSConfig
+	security       config.SecurityConfig
+	rateLimits     *rateLimits
+	compression    config.CompressionConfig
+	static         *staticAssets
+	health         healthState
+	takeouts       *takeoutJobs
+}
+
+// requestDB returns the database bound to the request logger
+func (env *environment) requestDB(r *http.Request) iDB {
+	return env.db.withContext(r.Context())
 }
 
 func (env *environment) readUserData(r *http.Request) (userData TwsUserData, err error) {
@@ -57,27 +93,14 @@ func (env *environment) readUserData(r *http.Request) (userData TwsUserData, err
 	return
 }
 
-func getPathValue(r *http.Request, pathCheck *regexp.Regexp) (string, error) {
-	m := pathCheck.FindStringSubmatch(r.URL.Path)
-	if m == nil {
-		return "", fmt.Errorf("url path is not valid")
-	}
-
-	log.Printf("getPathValue will return %v", m[2])
-	return m[2], nil
-}
-
-func tryToGetPostIdFromUrl(w http.ResponseWriter, r *http.Request, require bool) (int, error) {
+func tryToGetPostIdFromUrl(r *http.Request, require bool) (int, error) {
 	values, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return -1, err
 	}
 	postIDBuf, ok := values["postID"]
-	log.Printf("postID received = %s\n", postIDBuf)
 	if !ok {
 		if require {
-			http.Error(w, "postID wasn't provided", http.StatusBadRequest)
 			return -1, fmt.Errorf("postID wasn't provided")
 		} else {
 			return -1, nil
@@ -85,7 +108,6 @@ func tryToGetPostIdFromUrl(w http.ResponseWriter, r *http.Request, require bool)
 	}
 	postID, err := strconv.Atoi(postIDBuf[0])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
 		return -1, err
 	}
 
@@ -93,147 +115,131 @@ func tryToGetPostIdFromUrl(w http.ResponseWriter, r *http.Request, require bool)
 }
 
 func (env *environment) viewHandler(w http.ResponseWriter, r *http.Request) {
-	pageTitle, err := getPathValue(r, validPath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	pageTitle := pathParam(r, "title")
+	if len(pageTitle) == 0 {
+		env.renderError(w, r, http.StatusBadRequest, fmt.Errorf("page title wasn't provided"))
 		return
 	}
 
-	pageData, err := env.db.GetPage(pageTitle)
+	pageData, err := env.requestDB(r).GetPage(pageTitle)
 	if err != nil {
 		http.Redirect(w, r, "/edit/"+pageTitle, http.StatusFound)
 		return
 	}
 
-	session, err := env.sessionManager.ReadSession(r)
-	userData := TwsUserData{}
-	if err != nil {
-		log.Printf(err.Error())
-	} else {
-		userData.FillSessionData(session)
-	}
-	renderTemplate(w, "view", &Page{
-		Title: pageTitle,
-		Body:  pageData,
-		UData: userData,
-	})
+	env.render(w, r, "view.html", &Page{Title: pageTitle, Body: pageData})
 }
 
 func (env *environment) editHandler(w http.ResponseWriter, r *http.Request) {
-	pageTitle, err := getPathValue(r, validPath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	pageTitle := pathParam(r, "title")
+	if len(pageTitle) == 0 {
+		env.renderError(w, r, http.StatusBadRequest, fmt.Errorf("page title wasn't provided"))
 		return
 	}
 
-	userData, err := env.readUserData(r)
-	if err != nil {
-		log.Printf(err.Error())
-	}
-	page := &Page{Title: pageTitle, UData: userData}
-	renderTemplate(w, "edit", page)
+	env.render(w, r, "edit.html", &Page{Title: pageTitle})
 }
 
 func (env *environment) saveHandler(w http.ResponseWriter, r *http.Request) {
-	pageTitle, err := getPathValue(r, validPath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	pageTitle := pathParam(r, "title")
+	if len(pageTitle) == 0 {
+		env.renderError(w, r, http.StatusBadRequest, fmt.Errorf("page title wasn't provided"))
 		return
 	}
 
 	body := r.FormValue("body")
-	log.Printf("Current body is - %v", body)
+	requestLog(r).Info("saving page", "title", pageTitle, "size", len(body))
 	p := &Page{Title: pageTitle, Body: []byte(body)}
-	err = p.save(env.db)
+	err := p.save(env.requestDB(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		env.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
+	env.addFlash(r, session.FlashSuccess, "flash.page_saved")
 	http.Redirect(w, r, "/view/"+pageTitle, http.StatusFound)
 }
 
 func (env *environment) githubHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 
+	reqLog := requestLog(r)
 	code := r.FormValue("code")
 	stateCheck := r.FormValue("state")
 	if len(code) == 0 || stateCheck != randomStateString {
-		log.Printf("Something wrong with authentication response: code [%v], state [%v]", code, stateCheck)
+		reqLog.Warn("something wrong with authentication response", "has_code", len(code) > 0, "state_matches", stateCheck == randomStateString)
+		oauthLogins.Inc("failure")
 		http.Redirect(w, r, "/index", http.StatusFound)
 		return
 	}
-	log.Printf("Received authorization code - %v", code)
+	reqLog.Debug("received authorization code", "auth_code", code)
 
 	tok, err := env.oauth.Exchange(ctx, code)
 	if err != nil {
-		log.Printf(err.Error())
+		reqLog.Warn("couldn't exchange authorization code", "err", err)
+		oauthLogins.Inc("failure")
 		http.Redirect(w, r, "/index", http.StatusFound)
 		return
 	}
-	log.Printf("Retrieved initial access token %v", tok)
+	reqLog.Debug("retrieved initial access token", "token_type", tok.TokenType, "expiry", tok.Expiry)
 
 	client := env.oauth.Client(ctx, tok)
 	resp, err := client.Get("https://api.github.com/user")
 	if err != nil {
-		log.Printf(err.Error())
+		reqLog.Warn("couldn't get github user data", "err", err)
+		oauthLogins.Inc("failure")
 		http.Redirect(w, r, "/index", http.StatusFound)
 		return
 	}
 
 	respBody, err := ioutil.ReadAll(resp.Body)
-	log.Printf("Received response with user data %v", string(respBody))
-	userData, err := loadUserData(env.db, respBody)
+	userData, err := loadUserData(env.requestDB(r), respBody)
 	if err != nil {
-		log.Printf(err.Error())
+		reqLog.Warn("couldn't load user data", "err", err)
+		oauthLogins.Inc("failure")
 		http.Redirect(w, r, "/index", http.StatusFound)
 		return
 	}
 	session := env.sessionManager.StartSession(w, r)
-	log.Println("Kicked off session")
+	reqLog.Info("user logged in", "user_id", userData.Id)
+	oauthLogins.Inc("success")
 	session.Set("userId", userData.Id)
 	session.Set("avatarUrl", userData.AvatarUrl)
 	session.Set("adminRight", userData.AdminRight)
+	if len(userData.Locale) > 0 {
+		session.Set(localeSessionKey, userData.Locale)
+	}
 
 	http.Redirect(w, r, "/profile", http.StatusFound)
 }
 
 type ProfilePage struct {
-	SessionOwnerData TwsUserData
 	Posts            []twsPost
 	ProfileOwnerData TwsUserData
 }
 
 func (env *environment) profileHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := env.sessionManager.ReadSession(r)
+	sessionOwner, err := env.readUserData(r)
 	if err != nil {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
-	var postsPage ProfilePage
-	postsPage.SessionOwnerData.FillSessionData(session)
-
-	postsPage.ProfileOwnerData.Id = postsPage.SessionOwnerData.Id
-	postsPage.ProfileOwnerData.AvatarUrl = postsPage.SessionOwnerData.AvatarUrl
-
-	pathCheck := regexp.MustCompile("^/(profile)/([a-zA-Z0-9]*)$")
-	userID, err := getPathValue(r, pathCheck)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+	postsPage := ProfilePage{}
+	postsPage.ProfileOwnerData.Id = sessionOwner.Id
+	postsPage.ProfileOwnerData.AvatarUrl = sessionOwner.AvatarUrl
 
+	userID := pathParam(r, "id")
 	if len(userID) > 0 {
-		user, err := env.db.getUser(userID)
+		user, err := env.requestDB(r).getUser(userID)
 		if err == nil {
 			postsPage.ProfileOwnerData.Id = userID
 			postsPage.ProfileOwnerData.AvatarUrl = user.AvatarUrl
 		} else {
-			log.Println(err)
+			requestLog(r).Warn("couldn't load profile owner", "user_id", userID, "err", err)
 		}
 	}
 
 	//TODO: Implement additional loading for posts
-	posts, err := env.db.getLatestUserPosts([]byte(postsPage.ProfileOwnerData.Id), 64, 0)
+	posts, err := env.requestDB(r).getLatestUserPosts([]byte(postsPage.ProfileOwnerData.Id), 64, 0)
 	for _, p := range posts {
 		//Get user data for the standard posts
 		post := &twsPost{
@@ -244,29 +250,77 @@ func (env *environment) profileHandler(w http.ResponseWriter, r *http.Request) {
 		post.Type = figureOutDbPostType(&p)
 		err = post.convertFromDBPost(&p)
 		if err != nil {
-			log.Println(err)
+			requestLog(r).Warn("couldn't convert post", "err", err)
 			continue
 		}
 		if post.Type != PostType_Post {
-			repostedPost := &twsPost{}
-			err = repostedPost.constructUserPost(env.db, p.RepostId)
+			repostedPost, err := loadRepostedPost(env.requestDB(r), p.RepostId)
 			if err != nil {
-				log.Println(err)
+				requestLog(r).Warn("couldn't load reposted post", "post_id", p.RepostId, "err", err)
 			}
 			post.Repost = repostedPost
 		}
 		postsPage.Posts = append(postsPage.Posts, *post)
 	}
+	env.markLiked(r, postPointers(postsPage.Posts)...)
+
+	env.render(w, r, "profile.html", postsPage)
+}
+
+type PostPage struct {
+	Post twsPost
+}
+
+func (env *environment) postHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := env.loadRequestedPost(w, r)
+	if !ok {
+		return
+	}
+	env.render(w, r, "post.html", &PostPage{Post: post})
+}
+
+// embedPostHandler renders the read-only post which other sites are allowed to put into a frame
+func (env *environment) embedPostHandler(w http.ResponseWriter, r *http.Request) {
+	post, ok := env.loadRequestedPost(w, r)
+	if !ok {
+		return
+	}
+	env.render(w, r, "post_embed.html", &PostPage{Post: post})
+}
+
+// loadRequestedPost loads the post from the {id} path parameter together with the reposted post,
+// on failure the error page is rendered
+func (env *environment) loadRequestedPost(w http.ResponseWriter, r *http.Request) (twsPost, bool) {
+	postID, err := strconv.Atoi(pathParam(r, "id"))
+	if err != nil {
+		env.renderError(w, r, http.StatusBadRequest, err)
+		return twsPost{}, false
+	}
 
-	err = templates.ExecuteTemplate(w, "profile.html", postsPage)
+	dbPost, err := env.requestDB(r).getUserPost(postID)
+	if err != nil {
+		env.renderError(w, r, http.StatusNotFound, err)
+		return twsPost{}, false
+	}
+	post := twsPost{}
+	err = post.constructUserPost(env.requestDB(r), postID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return twsPost{}, false
 	}
+	if post.Type != PostType_Post {
+		repostedPost, err := loadRepostedPost(env.requestDB(r), dbPost.RepostId)
+		if err != nil {
+			requestLog(r).Warn("couldn't load reposted post", "post_id", dbPost.RepostId, "err", err)
+		}
+		post.Repost = repostedPost
+	}
+	env.markLiked(r, &post)
+	return post, true
 }
 
 type ComposePostPageData struct {
-	SessionOwnerData TwsUserData
-	Post             twsPost
+	Post twsPost
 }
 
 func (env *environment) composePostHandler(w http.ResponseWriter, r *http.Request) {
@@ -276,105 +330,143 @@ func (env *environment) composePostHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	postId, err := tryToGetPostIdFromUrl(w, r, false)
+	postId, err := tryToGetPostIdFromUrl(r, false)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		env.renderError(w, r, http.StatusBadRequest, err)
 		return
 	}
 	post := twsPost{}
 	if postId > 0 {
-		err = post.constructUserPost(env.db, postId)
+		err = post.constructUserPost(env.requestDB(r), postId)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			env.renderError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 	}
 
-	log.Printf("environment::composePostHandler will execute with post data %+v", post)
-	err = templates.ExecuteTemplate(w, "compose_post.html", &ComposePostPageData{
-		SessionOwnerData: userData,
-		Post:             post,
-	})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	env.render(w, r, "compose_post.html", &ComposePostPageData{Post: post})
 }
 
 func (env *environment) savePostHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("savePostHandler()")
 	userData, err := env.readUserData(r)
 	if err != nil {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
-	postTextRaw := r.FormValue("body")
-	if len(postTextRaw) > 240 {
-		http.Redirect(w, r, "/", http.StatusFound)
+	postForRepostId, err := tryToGetPostIdFromUrl(r, false)
+	if err != nil {
+		env.renderError(w, r, http.StatusBadRequest, err)
 		return
 	}
-	postTextClean := env.sanitizer.Sanitize(postTextRaw)
+	composeURL := "/compose_post/"
+	if postForRepostId > 0 {
+		composeURL += "?postID=" + strconv.Itoa(postForRepostId)
+	}
 
-	postForRepostId, err := tryToGetPostIdFromUrl(w, r, false)
-	log.Printf("environment::savePostHandler tryToGetPostIdFromUrl return -> %v", postForRepostId)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	postTextRaw := r.FormValue("body")
+	if len(postTextRaw) > maxPostLength {
+		env.addFlash(r, session.FlashError, "flash.post_too_long", maxPostLength)
+		http.Redirect(w, r, composeURL, http.StatusFound)
 		return
 	}
+	postTextClean := env.sanitizer.Sanitize(postTextRaw)
 	if postForRepostId > 0 {
 		postForRepost := twsPost{}
-		err := postForRepost.constructUserPost(env.db, postForRepostId)
+		err := postForRepost.constructUserPost(env.requestDB(r), postForRepostId)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			env.renderError(w, r, http.StatusBadRequest, err)
 			return
 		}
 		//Currently, we don't support reposts of any kind of other quotes
 		if postForRepost.Type == PostType_Quote {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			env.renderError(w, r, http.StatusBadRequest, fmt.Errorf("quotes can't be reposted"))
 			return
 		}
 
-		_, err = env.db.repostUserPost(utils.Itob(postForRepostId), []byte(userData.Id), postTextClean)
+		_, err = env.requestDB(r).repostUserPost(utils.Itob(postForRepostId), []byte(userData.Id), postTextClean)
 	} else {
 		if len(postTextClean) == 0 {
-			http.Error(w, "/", http.StatusBadRequest)
+			env.addFlash(r, session.FlashWarning, "flash.post_empty")
+			http.Redirect(w, r, composeURL, http.StatusFound)
 			return
 		}
-		_, err = env.db.saveUserPost([]byte(userData.Id), postTextClean)
+		_, err = env.requestDB(r).saveUserPost([]byte(userData.Id), postTextClean)
 	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		env.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
+	env.addFlash(r, session.FlashSuccess, "flash.post_published")
 	http.Redirect(w, r, "/profile/", http.StatusFound)
 }
 
+// localeHandler saves the language chosen in the navbar, the empty choice follows the browser again
+func (env *environment) localeHandler(w http.ResponseWriter, r *http.Request) {
+	userData, err := env.readUserData(r)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	locale := r.PostFormValue("locale")
+	if len(locale) > 0 && !translations.Supported(locale) {
+		env.renderError(w, r, http.StatusBadRequest, fmt.Errorf("unsupported locale %q", locale))
+		return
+	}
+	if err := env.requestDB(r).setUserLocale([]byte(userData.Id), locale); err != nil {
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	userSession, err := env.sessionManager.ReadSession(r)
+	if err != nil {
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if len(locale) > 0 {
+		userSession.Set(localeSessionKey, locale)
+	} else {
+		userSession.Delete(localeSessionKey)
+	}
+	env.addFlash(r, session.FlashSuccess, "flash.locale_saved")
+	http.Redirect(w, r, localReferer(r, "/profile/"), http.StatusFound)
+}
+
+// localReferer returns the path of the page the request was sent from, the other sites fall back to the path
+func localReferer(r *http.Request, fallback string) string {
+	referer, err := url.Parse(r.Referer())
+	if err != nil || referer.Host != r.Host || !strings.HasPrefix(referer.Path, "/") {
+		return fallback
+	}
+	return referer.RequestURI()
+}
+
 func (env *environment) deletePostHandler(w http.ResponseWriter, r *http.Request) {
 	userData, err := env.readUserData(r)
 	if err != nil {
 		//TODO: What should we do here?
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		env.renderError(w, r, http.StatusBadRequest, err)
 		return
 	}
-	postID, err := tryToGetPostIdFromUrl(w, r, true)
+	postID, err := tryToGetPostIdFromUrl(r, true)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		env.renderError(w, r, http.StatusBadRequest, err)
 		return
 	}
-	post, err := env.db.getUserPost(postID)
+	post, err := env.requestDB(r).getUserPost(postID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		env.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 	if strings.Compare(string(post.CreatorId), userData.Id) != 0 {
-		//TODO: Make access denied window?
-		http.Error(w, "only the owner of post can delete it", http.StatusForbidden)
+		env.renderError(w, r, http.StatusForbidden, fmt.Errorf("only the owner of post can delete it"))
 		return
 	}
-	err = env.db.deleteUserPost([]byte(userData.Id), postID)
+	err = env.requestDB(r).deleteUserPost([]byte(userData.Id), postID)
 	if err != nil {
-		log.Println(err)
+		requestLog(r).Error("couldn't delete post", "post_id", postID, "err", err)
+		env.addFlash(r, session.FlashError, "flash.post_delete_failed")
+	} else {
+		env.addFlash(r, session.FlashSuccess, "flash.post_deleted")
 	}
 
 	//TODO: Redirect is funky, should be replaced with something
@@ -388,16 +480,21 @@ func (env *environment) likePostHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	postId, err := tryToGetPostIdFromUrl(w, r, true)
-	post, err := env.db.getUserPost(postId)
+	postId, err := tryToGetPostIdFromUrl(r, true)
+	if err != nil {
+		env.renderError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	post, err := env.requestDB(r).getUserPost(postId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		env.renderError(w, r, http.StatusNotFound, err)
 		return
 	}
 
-	err = env.db.toggleLikeOnUserPost(post.CreatorId, postId, userData.Id)
+	err = env.requestDB(r).toggleLikeOnUserPost(post.CreatorId, postId, userData.Id)
 	if err != nil {
-		log.Println(err)
+		requestLog(r).Error("couldn't toggle like", "post_id", postId, "err", err)
+		env.addFlash(r, session.FlashError, "flash.like_failed")
 	}
 
 	//TODO: Redirect is funky, should be replaced with something
@@ -413,24 +510,25 @@ func (env *environment) repostPostHandler(w http.ResponseWriter, r *http.Request
 
 	values, err := url.ParseQuery(r.URL.RawQuery)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		env.renderError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 	postIDBuf, ok := values["postID"]
-	log.Printf("post id received = %s\n", postIDBuf)
 	if !ok || len(postIDBuf) == 0 {
-		http.Error(w, "/", http.StatusBadRequest)
+		env.renderError(w, r, http.StatusBadRequest, fmt.Errorf("postID wasn't provided"))
+		return
 	}
 	postTextRaw := r.FormValue("body")
-	if len(postTextRaw) > 240 || len(postTextRaw) == 0 {
-		http.Error(w, "/", http.StatusFound)
+	if len(postTextRaw) > maxPostLength || len(postTextRaw) == 0 {
+		env.renderError(w, r, http.StatusBadRequest, fmt.Errorf("post text length must be between 1 and 240"))
 		return
 	}
 	postTextClean := env.sanitizer.Sanitize(postTextRaw)
 
-	_, err = env.db.repostUserPost([]byte(postIDBuf[0]), []byte(userData.Id), postTextClean)
+	_, err = env.requestDB(r).repostUserPost([]byte(postIDBuf[0]), []byte(userData.Id), postTextClean)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		env.renderError(w, r, http.StatusInternalServerError, err)
+		return
 	}
 
 	//TODO: Redirect is funky, should be replaced with something
@@ -440,7 +538,6 @@ func (env *environment) repostPostHandler(w http.ResponseWriter, r *http.Request
 type Page struct {
 	Title string
 	Body  []byte
-	UData TwsUserData
 }
 
 const (
@@ -452,16 +549,24 @@ const (
 type twsPost struct {
 	PostId       int
 	Text         string
-	Likes        []string
+	LikesCount   int
+	Liked        bool // whether the logged in user liked the post
 	CreationDate string
 	OwnerId      string
 	OwnerName    string
 	OwnerAvatar  string
 	Type         int
 	Repost       *twsPost
+	// Deleted marks the reposted post which doesn't exist anymore
+	Deleted bool
 }
 
+// ConstructUserProfileUrl links to the profile of the creator, the anonymized posts of the deleted
+// accounts have none
 func (post *twsPost) ConstructUserProfileUrl() string {
+	if len(post.OwnerId) == 0 {
+		return "#"
+	}
 	return "/profile/" + post.OwnerId
 }
 
@@ -471,13 +576,16 @@ func (post *twsPost) constructUserPost(db iDB, postId int) error {
 	if err != nil {
 		return err
 	}
-	dbPostCreator, err := db.getUser(string(dbPost.CreatorId))
-	if err != nil {
-		return err
+	dbPostCreator := dbUserData{}
+	if len(dbPost.CreatorId) > 0 {
+		dbPostCreator, err = db.getUser(string(dbPost.CreatorId))
+		if err != nil {
+			return err
+		}
 	}
 
 	post.PostId = dbPost.postId
-	post.Likes = dbPost.Likes
+	post.LikesCount = dbPost.LikesCount
 	post.OwnerName = string(dbPost.CreatorId)
 	post.OwnerId = string(dbPost.CreatorId)
 	post.OwnerAvatar = dbPostCreator.AvatarUrl
@@ -496,6 +604,25 @@ func (post *twsPost) constructUserPost(db iDB, postId int) error {
 	return nil
 }
 
+// loadRepostedPost loads the post the repost or the quote points at. The posts removed before the
+// reposts were cleaned up on deletion are returned as deleted, so the cards show a placeholder
+func loadRepostedPost(db iDB, postID int) (*twsPost, error) {
+	post := &twsPost{}
+	err := post.constructUserPost(db, postID)
+	if errors.Is(err, errPostNotExist) {
+		return &twsPost{PostId: postID, Deleted: true}, nil
+	}
+	return post, err
+}
+
+func postPointers(posts []twsPost) []*twsPost {
+	pointers := make([]*twsPost, len(posts))
+	for i := range posts {
+		pointers[i] = &posts[i]
+	}
+	return pointers
+}
+
 func figureOutDbPostType(post *dbPost) int {
 	if post.RepostId > 0 {
 		if len(post.Text) > 0 {
@@ -512,7 +639,7 @@ func (dest *twsPost) convertFromDBPost(src *dbPost) error {
 		return fmt.Errorf("received empty post")
 	}
 	dest.Text = src.Text
-	dest.Likes = src.Likes
+	dest.LikesCount = src.LikesCount
 	dest.CreationDate = string(src.CreationDate)
 	dest.PostId = src.postId
 	return nil
@@ -534,6 +661,7 @@ type TwsUserData struct {
 	AvatarUrl  string
 	AdminRight UserRight
 	IsLogged   bool
+	Locale     string
 }
 
 func (userData *TwsUserData) FillSessionData(session session.Session) {
@@ -544,122 +672,199 @@ func (userData *TwsUserData) FillSessionData(session session.Session) {
 	ok := false
 	userData.Id, ok = session.Get("userId").(string)
 	if !ok {
-		log.Printf("no userId information inside session")
+		serverLog.Debug("no userId information inside session")
 	}
 	userData.AvatarUrl, ok = session.Get("avatarUrl").(string)
 	if !ok {
-		log.Printf("no avatarUrl information inside session")
+		serverLog.Debug("no avatarUrl information inside session")
 	}
-	userData.AdminRight, _ = session.Get("adminRight").(UserRight)
+	userData.AdminRight, ok = session.Get("adminRight").(UserRight)
 	if !ok {
-		log.Printf("no adminRight information inside session")
+		serverLog.Debug("no adminRight information inside session")
 	}
+	userData.Locale, _ = session.Get(localeSessionKey).(string)
 	userData.IsLogged = true
-
-	log.Printf("Current session data - %+v", userData)
 }
 
-//TODO: make page an interface?
-func renderTemplate(w http.ResponseWriter, tmpl string, p *Page) {
-	err := templates.ExecuteTemplate(w, tmpl+".html", p)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/view/index", http.StatusFound)
 }
 
-func cssHandler(w http.ResponseWriter, r *http.Request, title string) {
-	fileHandler(w, r, "text/css")
-}
+var serverLog = logger.New("server")
 
-func iconHandler(w http.ResponseWriter, r *http.Request, title string) {
-	fileHandler(w, r, "image/png")
+// requestLog returns the logger carrying the request ID
+func requestLog(r *http.Request) *logger.Logger {
+	return logger.FromContext(r.Context(), serverLog)
 }
 
-func jsHandler(w http.ResponseWriter, r *http.Request, title string) {
-	fileHandler(w, r, "text/javascript")
-}
+var templates *templateSet
 
-func fileHandler(w http.ResponseWriter, r *http.Request, contentType string) {
-	filename := r.URL.Path[len("/"):]
-	body, err := ioutil.ReadFile(filename)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+// translations are the message catalogs of the UI
+var translations *i18n.Bundle
 
-	w.Header().Add("Content-Type", contentType)
-	_, err = w.Write(body)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	log.Printf("file handler successfully returend")
-}
+// localesPath is the directory of the message catalogs inside of the assets
+const localesPath = "locales"
 
-func rootHandler(w http.ResponseWriter, r *http.Request, title string) {
-	http.Redirect(w, r, "/view/index", http.StatusFound)
+func newHTTPServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:           cfg.Addr,
+		Handler:        handler,
+		ReadTimeout:    cfg.ReadTimeout,
+		WriteTimeout:   cfg.WriteTimeout,
+		IdleTimeout:    cfg.IdleTimeout,
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+		ErrorLog:       log.New(serverLog.Writer(logger.LevelWarn), "", 0),
+	}
 }
 
-func makeHandler(fn func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Trying to process request %s", r.URL)
-
-		m := validPath.FindStringSubmatch(r.URL.Path)
-		if m == nil {
-			http.NotFound(w, r)
-			return
+// Start serves the requests until SIGINT or SIGTERM is received, then drains the connections and
+// releases the resources. The error is returned only when the server couldn't serve at all.
+// The templates and the static files are read from assets unless assets.dir is configured.
+func Start(cfg *config.Config, assets fs.FS) error {
+	if err := cfg.ValidateServe(); err != nil {
+		return err
+	}
+	cached := true
+	if len(cfg.Assets.Dir) > 0 {
+		assets = os.DirFS(cfg.Assets.Dir)
+		cached = false
+	}
+	static, err := newStaticAssets(assets, cached)
+	if err != nil {
+		return err
+	}
+	if cfg.Compression.Enabled {
+		if err := static.precompress(cfg.Compression.MinSize); err != nil {
+			return err
 		}
-		fn(w, r, m[2])
 	}
-}
-
-var templatesPath string
-var templates *template.Template
-var validPath = regexp.MustCompile("^/(edit|save|view|test|login|compose_post|save_post|delete_post|like_post)/([a-zA-Z0-9]+)$|[/]|^/(/tmpl/css|/img/icons/)/([a-zA-Z0-9]+)")
-
-func init() {
-	templatesPath = "tmpl/"
-}
-
-func Start() {
-	//This cannot be located at start, because we want to overwrite templatesPath for tests
-	templates = template.Must(template.New("tmpl").Delims("<<", ">>").ParseFiles(templatesPath+"edit.html", templatesPath+"view.html", templatesPath+"test.html", templatesPath+"profile.html",
-		templatesPath+"compose_post.html"))
+	templates, err = newTemplateSet(assets, cfg.Templates.Path, templateFuncs(static), cfg.Templates.Reload)
+	if err != nil {
+		return fmt.Errorf("couldn't parse templates: %w", err)
+	}
+	translations, err = i18n.Load(assets, localesPath, cfg.I18n.DefaultLocale)
+	if err != nil {
+		return fmt.Errorf("couldn't load message catalogs: %w", err)
+	}
 
-	InitDB()
-	dbConnection, err := bolt.Open("data/tws.db", 0600, nil)
-	defer dbConnection.Close()
+	err = InitDB(cfg.Database.Path)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	dbConnection, err := bolt.Open(cfg.Database.Path, 0600, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't open database: %w", err)
+	}
+	defer func() {
+		if err := dbConnection.Close(); err != nil {
+			serverLog.Error("couldn't close database", "err", err)
+			return
+		}
+		serverLog.Info("database closed")
+	}()
 
-	sessionManager := session.NewManager("memory", "twssessionid", 3600)
+	limits, err := newRateLimits(cfg.RateLimit)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	sessionManager := session.NewManager("memory", cfg.Session.CookieName, int64(cfg.Session.Lifetime/time.Second))
+	sessionManager.SetSecureCookies(cfg.TLS.Enabled())
 	sessionManager.StartGC()
+	defer sessionManager.StopGC()
+	registerSessionMetrics(sessionManager)
 	env := environment{
 		db:             &twsDB{db: dbConnection},
-		oauth:          loadOauthConfig(),
+		oauth:          newOauth(cfg.Github),
 		sessionManager: sessionManager,
 		sanitizer:      bluemonday.StrictPolicy(),
+		debug:          cfg.Debug,
+		tls:            cfg.TLS,
+		security:       cfg.Security,
+		rateLimits:     limits,
+		compression:    cfg.Compression,
+		static:         static,
+		takeouts:       newTakeoutJobs(),
 	}
 
-	http.HandleFunc("/profile/", env.profileHandler)
-	http.HandleFunc("/compose_post/", env.composePostHandler)
-	http.HandleFunc("/save_post/", env.savePostHandler)
-	http.HandleFunc("/delete_post/", env.deletePostHandler)
-	http.HandleFunc("/like_post/", env.likePostHandler)
-	http.HandleFunc("/view/", env.viewHandler)
-	http.HandleFunc("/edit/", env.editHandler)
-	http.HandleFunc("/save/", env.saveHandler)
-	http.HandleFunc("/github", env.githubHandler)
-	http.HandleFunc("/login/", env.loginHandler)
-	http.HandleFunc("/logout/", env.logoutHandler)
-	http.HandleFunc("/tmpl/css/", makeHandler(cssHandler))
-	http.HandleFunc("/frontend/css/", makeHandler(cssHandler))
-	http.HandleFunc("/frontend/js/", makeHandler(jsHandler))
-	http.HandleFunc("/img/icons/", makeHandler(iconHandler))
-	http.HandleFunc("/", makeHandler(rootHandler))
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.Database.Backup.Enabled() {
+		go runScheduledBackups(ctx, env.db, cfg.Database.Backup)
+	}
+
+	listener, err := net.Listen("tcp", cfg.Server.Addr)
+	if err != nil {
+		return fmt.Errorf("couldn't listen on %v: %w", cfg.Server.Addr, err)
+	}
+	servers := []servedListener{{srv: newHTTPServer(cfg.Server, env.routes()), listener: listener}}
+	if cfg.TLS.Enabled() {
+		reloader, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		go reloader.watch(ctx, cfg.TLS.ReloadInterval)
+		servers[0].srv.TLSConfig = newTLSConfig(reloader)
+		servers[0].listener = tls.NewListener(listener, servers[0].srv.TLSConfig)
+
+		if len(cfg.TLS.RedirectAddr) > 0 {
+			redirectListener, err := net.Listen("tcp", cfg.TLS.RedirectAddr)
+			if err != nil {
+				listener.Close()
+				return fmt.Errorf("couldn't listen on %v: %w", cfg.TLS.RedirectAddr, err)
+			}
+			redirectServer := newHTTPServer(cfg.Server, redirectToHTTPS(cfg.Server.Addr))
+			redirectServer.Addr = cfg.TLS.RedirectAddr
+			servers = append(servers, servedListener{srv: redirectServer, listener: redirectListener})
+		}
+	}
+	return env.serve(ctx, cfg.Server.ShutdownDrain, cfg.Server.ShutdownTimeout, servers...)
+}
+
+type servedListener struct {
+	srv      *http.Server
+	listener net.Listener
+}
+
+// serve runs the servers until the context is cancelled or any of them fails, then marks the
+// instance as not ready, keeps serving for drain while the load balancers notice it and waits up
+// to shutdownTimeout for the in-flight requests
+func (env *environment) serve(ctx context.Context, drain, shutdownTimeout time.Duration, servers ...servedListener) error {
+	serveErr := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s servedListener) {
+			serverLog.Info("starting server", "addr", s.listener.Addr().String(), "tls", s.srv.TLSConfig != nil)
+			serveErr <- s.srv.Serve(s.listener)
+		}(s)
+	}
+
+	var err error
+	select {
+	case err = <-serveErr:
+		err = fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	serverLog.Info("shutting down server", "drain", drain, "timeout", shutdownTimeout)
+	env.beginShutdown()
+	// a failed server has nothing to drain
+	if err == nil {
+		time.Sleep(drain)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				serverLog.Warn("connections weren't drained in time", "err", err)
+				srv.Close()
+			}
+		}(s.srv)
+	}
+	wg.Wait()
+	serverLog.Info("server stopped")
+	return err
 }
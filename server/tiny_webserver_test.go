@@ -4,20 +4,27 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"github.com/matryer/is"
 	"golang.org/x/oauth2"
-	"html/template"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
+	"tinywebserver/i18n"
 	"tinywebserver/session"
 	"tinywebserver/utils"
 )
 
 type stubDB struct {
-	pageData Page
+	pageData    Page
+	userLocale  string
+	deletedUser string
 }
 
 func (db *stubDB) GetPage(title string) ([]byte, error) {
@@ -43,6 +50,22 @@ func (db *stubDB) getLatestUserPosts(ownerID []byte, maxPostsToGet int, lastKey
 	return nil, nil
 }
 
+func (db *stubDB) getUserPostIDs(userID string) ([]int, error) {
+	return nil, nil
+}
+
+func (db *stubDB) getPostLikes(postID int, after string, limit int) (userIDs []string, next string, err error) {
+	return nil, "", nil
+}
+
+func (db *stubDB) getUserLikes(userID string, postIDs []int) (map[int]bool, error) {
+	return nil, nil
+}
+
+func (db *stubDB) getPostsByTime(query postsQuery) (posts []dbPost, next string, err error) {
+	return nil, "", nil
+}
+
 func (db *stubDB) saveUserPost(ownerID []byte, post string) (postID int, err error) {
 	return 0, nil
 }
@@ -55,6 +78,16 @@ func (db *stubDB) toggleLikeOnUserPost(ownerID []byte, postID int, likeOwner str
 	return nil
 }
 
+func (db *stubDB) setUserLocale(userID []byte, locale string) error {
+	db.userLocale = locale
+	return nil
+}
+
+func (db *stubDB) deleteUser(userID string, policy deletionPolicy) (deletionStats, error) {
+	db.deletedUser = userID
+	return deletionStats{}, nil
+}
+
 func (db *stubDB) SyncUser(userData TwsUserData) (TwsUserData, error) {
 	return userData, nil
 }
@@ -71,6 +104,23 @@ func (db *stubDB) getUserPosts(postsId []int) (posts []dbPost, err error) {
 	return
 }
 
+func (db *stubDB) getLikedPosts(userID string) (posts []dbPost, err error) {
+	return
+}
+
+func (db *stubDB) withContext(ctx context.Context) iDB {
+	return db
+}
+
+func (db *stubDB) backup(w io.Writer) (int64, error) {
+	n, err := w.Write([]byte("snapshot"))
+	return int64(n), err
+}
+
+func (db *stubDB) checkHealth() error {
+	return nil
+}
+
 func (db *stubDB) repostUserPost(postToRepostId []byte, reposterId []byte, reposterText string) (resultPostId int, err error) {
 	return
 }
@@ -118,39 +168,27 @@ func checkIfRedirect(rec *httptest.ResponseRecorder, expectedRedirect string, t
 	}
 }
 
-func init() {
-	templatesPath = "../tmpl/"
-	templates = template.Must(template.New("test_tmpl").Delims("<<", ">>").ParseFiles(templatesPath+"edit.html", templatesPath+"view.html", templatesPath+"test.html", templatesPath+"profile.html"))
-}
-
-func TestGetPageTitle(t *testing.T) {
-	expectedTitles := []string{"title1", "", "123ljkjads"}
-	testCases := []string{"/view/", "/edit/", "/save/"}
-	for index, testCase := range testCases {
-		title := expectedTitles[index]
-		req := httptest.NewRequest(http.MethodGet, testCase+title, nil)
-		resultTitle, err := getPathValue(req, validPath)
-		if err != nil {
-			t.Errorf("Boom")
-		}
-		if resultTitle != title {
-			t.Errorf("Expected %v, got %v", title, resultTitle)
-		}
-	}
+const testCSRFToken = "test-csrf-token"
 
-	req := httptest.NewRequest(http.MethodGet, "/invalidparent/title_to_die", nil)
-	resultTitle, err := getPathValue(req, validPath)
-	if len(resultTitle) > 0 {
-		t.Errorf("Expected empty title, got %v", resultTitle)
-	}
+// loginTestUser starts a session for the request and fills it with the user data
+func loginTestUser(env *environment, req *http.Request, userData TwsUserData) {
+	req.AddCookie(&http.Cookie{Name: env.sessionManager.CookieName(), Value: utils.RandString(32)})
+	session := env.sessionManager.StartSession(httptest.NewRecorder(), req)
+	session.Set("userId", userData.Id)
+	session.Set("avatarUrl", userData.AvatarUrl)
+	session.Set("adminRight", userData.AdminRight)
+	session.Set(csrfSessionKey, testCSRFToken)
+}
 
-	req.URL.Path = "invalidPath"
-	resultTitle, err = getPathValue(req, validPath)
-	if err == nil {
-		t.Errorf("Expected error to be non nil")
+func init() {
+	var err error
+	templates, err = newTemplateSet(os.DirFS(".."), "tmpl", templateFuncs(nil), false)
+	if err != nil {
+		panic(err)
 	}
-	if len(resultTitle) > 0 {
-		t.Errorf("Expected empty title, got %v", resultTitle)
+	translations, err = i18n.Load(os.DirFS(".."), localesPath, "en")
+	if err != nil {
+		panic(err)
 	}
 }
 
@@ -166,12 +204,13 @@ func TestViewHandler(t *testing.T) {
 		},
 		sessionManager: session.NewManager("memory", "twssessionid", 3600),
 	}
+	mux := env.routes()
 
 	testCase := "/view/"
 	rec := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, testCase+testTitle, nil)
 
-	http.HandlerFunc(env.viewHandler).ServeHTTP(rec, req)
+	mux.ServeHTTP(rec, req)
 	if rec.Code != http.StatusOK {
 		t.Errorf("Expected %v, got %v", http.StatusOK, rec.Code)
 	}
@@ -180,25 +219,24 @@ func TestViewHandler(t *testing.T) {
 	}
 
 	rec2 := httptest.NewRecorder()
-	emptyEnv := environment{db: &stubDB{}}
+	req2, _ := http.NewRequest(http.MethodGet, testCase+"nopage", nil)
+	mux.ServeHTTP(rec2, req2)
+	checkIfRedirect(rec2, "/edit/nopage", t)
 
-	req.URL.Path = "/view/nopage"
-	http.NewRequest(http.MethodGet, testCase+testTitle, nil)
-	http.HandlerFunc(emptyEnv.viewHandler).ServeHTTP(rec2, req)
-	if rec2.Code != http.StatusFound {
-		t.Errorf("Expected %v, got %v", http.StatusFound, rec2.Code)
-	}
-	expectedRedirect := "/edit/nopage"
-	redirect := rec2.Header().Get("Location")
-	if redirect != expectedRedirect {
-		t.Errorf("Expected %s, got %s", expectedRedirect, redirect)
+	//Invalid path flow
+	req3, _ := http.NewRequest(http.MethodGet, "/invalidparent/title", nil)
+	rec3 := httptest.NewRecorder()
+	mux.ServeHTTP(rec3, req3)
+	if rec3.Code != http.StatusNotFound {
+		t.Errorf("Expected %v, got %v", http.StatusNotFound, rec3.Code)
 	}
 
-	req.URL.Path = "invalidPath"
-	rec3 := httptest.NewRecorder()
-	http.HandlerFunc(env.viewHandler).ServeHTTP(rec3, req)
-	if rec3.Code != http.StatusBadRequest {
-		t.Errorf("Expected %v, got %v", http.StatusBadRequest, rec3.Code)
+	//Wrong method flow
+	req4, _ := http.NewRequest(http.MethodPost, testCase+testTitle, nil)
+	rec4 := httptest.NewRecorder()
+	mux.ServeHTTP(rec4, req4)
+	if rec4.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %v, got %v", http.StatusMethodNotAllowed, rec4.Code)
 	}
 }
 
@@ -214,11 +252,15 @@ func TestEditHandler(t *testing.T) {
 		},
 		sessionManager: session.NewManager("memory", "twssessionid", 3600),
 	}
+	mux := env.routes()
 
 	rec := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "/edit/"+testTitle, nil)
+	adminData := defaultTestUserData
+	adminData.AdminRight = ADMIN
+	loginTestUser(&env, req, adminData)
 
-	http.HandlerFunc(env.editHandler).ServeHTTP(rec, req)
+	mux.ServeHTTP(rec, req)
 	if rec.Code != http.StatusOK {
 		t.Errorf("Expected %v, got %v", http.StatusOK, rec.Code)
 	}
@@ -226,54 +268,63 @@ func TestEditHandler(t *testing.T) {
 		t.Errorf("Expected %s to be on the Page, got %s", testTitle, rec.Body.String())
 	}
 
-	//Invalid path flow
-	req.URL.Path = "invalidPath"
+	//Not logged in flow
+	req2, _ := http.NewRequest(http.MethodGet, "/edit/"+testTitle, nil)
 	rec2 := httptest.NewRecorder()
-	http.HandlerFunc(env.editHandler).ServeHTTP(rec2, req)
-	if rec2.Code != http.StatusBadRequest {
-		t.Errorf("Expected %v, got %v", http.StatusBadRequest, rec2.Code)
+	mux.ServeHTTP(rec2, req2)
+	checkIfRedirect(rec2, "/", t)
+
+	//Not an admin flow
+	req3, _ := http.NewRequest(http.MethodGet, "/edit/"+testTitle, nil)
+	loginTestUser(&env, req3, defaultTestUserData)
+	rec3 := httptest.NewRecorder()
+	mux.ServeHTTP(rec3, req3)
+	if rec3.Code != http.StatusForbidden {
+		t.Errorf("Expected %v, got %v", http.StatusForbidden, rec3.Code)
 	}
 }
 
 func TestSaveHandler(t *testing.T) {
 	testTitle := "testPage"
 	testBody := "testBody"
-	env := environment{db: &stubDB{
-		pageData: Page{},
-	}}
+	env := environment{
+		db: &stubDB{
+			pageData: Page{},
+		},
+		sessionManager: session.NewManager("memory", "twssessionid", 3600),
+	}
+	mux := env.routes()
+	adminData := defaultTestUserData
+	adminData.AdminRight = ADMIN
 
 	//Normal flow
 	rec := httptest.NewRecorder()
-	r := strings.NewReader("body=" + testBody)
-	req, _ := http.NewRequest(http.MethodPost, "/save/"+testTitle, r)
+	req, _ := http.NewRequest(http.MethodPost, "/save/"+testTitle, strings.NewReader("body="+testBody+"&csrf_token="+testCSRFToken))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	loginTestUser(&env, req, adminData)
 
-	http.HandlerFunc(env.saveHandler).ServeHTTP(rec, req)
-	if rec.Code != http.StatusFound {
-		t.Errorf("Expected %v, got %v", http.StatusFound, rec.Code)
-	}
-	expectedRedirect := "/view/" + testTitle
-	redirect := rec.Header().Get("Location")
-	if redirect != expectedRedirect {
-		t.Errorf("Expected %s, got %s", expectedRedirect, redirect)
-	}
+	mux.ServeHTTP(rec, req)
+	checkIfRedirect(rec, "/view/"+testTitle, t)
 	savedPage, _ := env.db.GetPage(testTitle)
 	if bytes.Compare([]byte(testBody), savedPage) != 0 {
 		t.Errorf("Expected request body [%s] and saved page [%s] to be equal", testBody, savedPage)
 	}
 
-	//Invalid path flow
-	req.URL.Path = "invalidPath"
+	//Wrong method flow
+	req2, _ := http.NewRequest(http.MethodGet, "/save/"+testTitle, nil)
+	loginTestUser(&env, req2, adminData)
 	rec2 := httptest.NewRecorder()
-	http.HandlerFunc(env.saveHandler).ServeHTTP(rec2, req)
-	if rec2.Code != http.StatusBadRequest {
-		t.Errorf("Expected %v, got %v", http.StatusBadRequest, rec2.Code)
+	mux.ServeHTTP(rec2, req2)
+	if rec2.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %v, got %v", http.StatusMethodNotAllowed, rec2.Code)
 	}
 
 	//Database couldn't save data flow
-	reqErr, _ := http.NewRequest(http.MethodPost, "/save/"+"error", r)
+	reqErr, _ := http.NewRequest(http.MethodPost, "/save/"+"error", strings.NewReader("body="+testBody+"&csrf_token="+testCSRFToken))
+	reqErr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	loginTestUser(&env, reqErr, adminData)
 	rec3 := httptest.NewRecorder()
-	http.HandlerFunc(env.saveHandler).ServeHTTP(rec3, reqErr)
+	mux.ServeHTTP(rec3, reqErr)
 	if rec3.Code != http.StatusInternalServerError {
 		t.Errorf("Expected %v, got %v", http.StatusInternalServerError, rec3.Code)
 	}
@@ -348,3 +399,109 @@ func TestGithubHandler(t *testing.T) {
 	http.HandlerFunc(env.githubHandler).ServeHTTP(rec4, req4)
 	checkIfRedirect(rec3, "/index", t)
 }
+
+func TestGracefulShutdown(t *testing.T) {
+	is := is.New(t)
+	env := environment{db: &stubDB{}, oauth: &stubOauth{}}
+	requestStarted := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- env.serve(ctx, 0, time.Second, servedListener{srv: srv, listener: listener}) }()
+
+	response := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		response <- string(body)
+	}()
+
+	<-requestStarted
+	cancel()
+	is.NoErr(<-served)
+	is.Equal(<-response, "done")
+	is.True(env.isShuttingDown())
+}
+
+func TestShutdownDrain(t *testing.T) {
+	is := is.New(t)
+	env := environment{db: &stubDB{}, oauth: &stubOauth{}}
+	srv := &http.Server{Handler: env.routes()}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	is.NoErr(err)
+	readyz := func() int {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/readyz")
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- env.serve(ctx, 300*time.Millisecond, time.Second, servedListener{srv: srv, listener: listener})
+	}()
+	is.Equal(readyz(), http.StatusOK)
+
+	// the listener keeps answering during the drain, with /readyz reporting not ready
+	cancel()
+	for !env.isShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+	is.Equal(readyz(), http.StatusServiceUnavailable)
+	is.NoErr(<-served)
+	is.Equal(readyz(), 0)
+}
+
+func TestPostActionsRequireCSRF(t *testing.T) {
+	is := is.New(t)
+	db := migratedTestDB(is, t)
+	createTestUser(is, db, "alice", "alice.png")
+	createTestUser(is, db, "bob", "bob.png")
+	postID, err := db.saveUserPost([]byte("alice"), "alice's post")
+	is.NoErr(err)
+	env := environment{db: db, sessionManager: session.NewManager("memory", "twssessionid", 3600)}
+	mux := env.routes()
+	send := func(method, target, token string, userData TwsUserData) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader("csrf_token="+token))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		loginTestUser(&env, req, userData)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	bob, alice := TwsUserData{Id: "bob"}, TwsUserData{Id: "alice"}
+	likeURL := fmt.Sprintf("/like_post/?postID=%v", postID)
+	deleteURL := fmt.Sprintf("/delete_post/?postID=%v", postID)
+
+	// the links of the older pages and the forged forms change nothing
+	is.Equal(send(http.MethodGet, likeURL, "", bob).Code, http.StatusMethodNotAllowed)
+	is.Equal(send(http.MethodPost, likeURL, "forged", bob).Code, http.StatusForbidden)
+	is.Equal(send(http.MethodGet, deleteURL, "", alice).Code, http.StatusMethodNotAllowed)
+	is.Equal(send(http.MethodPost, deleteURL, "forged", alice).Code, http.StatusForbidden)
+	post, err := db.getUserPost(postID)
+	is.NoErr(err)
+	is.Equal(post.LikesCount, 0)
+
+	checkIfRedirect(send(http.MethodPost, likeURL, testCSRFToken, bob), "/profile/alice", t)
+	post, err = db.getUserPost(postID)
+	is.NoErr(err)
+	is.Equal(post.LikesCount, 1)
+	checkIfRedirect(send(http.MethodPost, deleteURL, testCSRFToken, alice), "/profile", t)
+	_, err = db.getUserPost(postID)
+	is.True(err != nil)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"tinywebserver/config"
+)
+
+// certReloader serves the certificate and reloads it when the files change, the established
+// connections keep using the certificate they were opened with
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (reloader *certReloader) fileModTimes() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (reloader *certReloader) reload() error {
+	modTimes, err := reloader.fileModTimes()
+	if err != nil {
+		return fmt.Errorf("couldn't stat certificate files: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("couldn't load certificate: %w", err)
+	}
+
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+	reloader.cert = &cert
+	reloader.modTimes = modTimes
+	return nil
+}
+
+// reloadIfChanged reloads the certificate when any of the files was modified since the last load
+func (reloader *certReloader) reloadIfChanged() (bool, error) {
+	modTimes, err := reloader.fileModTimes()
+	if err != nil {
+		return false, err
+	}
+	reloader.lock.RLock()
+	changed := modTimes != reloader.modTimes
+	reloader.lock.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, reloader.reload()
+}
+
+func (reloader *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.lock.RLock()
+	defer reloader.lock.RUnlock()
+	return reloader.cert, nil
+}
+
+// watch checks the files every interval and reloads them on SIGHUP until the context is done.
+// The broken files are reported and the previous certificate keeps being served.
+func (reloader *certReloader) watch(ctx context.Context, interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := reloader.reload(); err != nil {
+				serverLog.Error("couldn't reload certificate", "err", err)
+				continue
+			}
+			serverLog.Info("certificate reloaded", "reason", "sighup")
+		case <-ticker.C:
+			changed, err := reloader.reloadIfChanged()
+			if err != nil {
+				serverLog.Error("couldn't reload certificate", "err", err)
+				continue
+			}
+			if changed {
+				serverLog.Info("certificate reloaded", "reason", "file changed")
+			}
+		}
+	}
+}
+
+func newTLSConfig(reloader *certReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+}
+
+// strictTransportSecurity makes browsers use HTTPS for all of the future requests
+func strictTransportSecurity(cfg config.TLSConfig) middleware {
+	value := "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge/time.Second), 10)
+	if cfg.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// redirectToHTTPS redirects the plain HTTP requests to the same URL on the HTTPS address
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if len(httpsPort) > 0 && httpsPort != "443" {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/matryer/is"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"tinywebserver/config"
+)
+
+func writeTestCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedCommonName(is *is.I, reloader *certReloader) string {
+	cert, err := reloader.GetCertificate(nil)
+	is.NoErr(err)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	is.NoErr(err)
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	is := is.New(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCertificate(t, certFile, keyFile, "first")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	is.NoErr(err)
+	is.Equal(servedCommonName(is, reloader), "first")
+
+	changed, err := reloader.reloadIfChanged()
+	is.NoErr(err)
+	is.True(!changed)
+
+	writeTestCertificate(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	is.NoErr(os.Chtimes(certFile, future, future))
+	changed, err = reloader.reloadIfChanged()
+	is.NoErr(err)
+	is.True(changed)
+	is.Equal(servedCommonName(is, reloader), "second")
+
+	// the broken files keep the previous certificate in place
+	is.NoErr(os.WriteFile(keyFile, []byte("broken"), 0600))
+	is.True(reloader.reload() != nil)
+	is.Equal(servedCommonName(is, reloader), "second")
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		httpsAddr, host, target, expected string
+	}{
+		{":443", "example.com", "/view/index?a=b", "https://example.com/view/index?a=b"},
+		{":443", "example.com:80", "/", "https://example.com/"},
+		{":8443", "example.com:8080", "/profile", "https://example.com:8443/profile"},
+		{":8443", "[::1]:8080", "/", "https://[::1]:8443/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(tt.httpsAddr).ServeHTTP(rec, req)
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.expected {
+			t.Errorf("redirect of %v%v should lead to %v, got %v %v", tt.host, tt.target, tt.expected, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestStrictTransportSecurity(t *testing.T) {
+	is := is.New(t)
+	tlsConfig := config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", HSTSMaxAge: time.Hour, HSTSIncludeSubdomains: true}
+	env := environment{db: &stubDB{}, tls: tlsConfig}
+
+	rec := httptest.NewRecorder()
+	env.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.Equal(rec.Header().Get("Strict-Transport-Security"), "max-age=3600; includeSubDomains")
+
+	env = environment{db: &stubDB{}}
+	rec = httptest.NewRecorder()
+	env.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.Equal(rec.Header().Get("Strict-Transport-Security"), "")
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+	"tinywebserver/i18n"
+	"tinywebserver/session"
+)
+
+const (
+	csrfSessionKey = "csrfToken"
+	// csrfFormField is the hidden field of the forms, the scripts send the token in csrfHeader instead
+	csrfFormField = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+	// localeSessionKey caches the locale chosen by the user, it's read from the database at login
+	localeSessionKey = "locale"
+)
+
+// viewData is passed to every page template, the layout and the partials read the common values
+// and the page itself reads its own view model from Page
+type viewData struct {
+	User      TwsUserData
+	CSRFToken string
+	Flashes   []session.Flash
+	Locale    *i18n.Locale
+	PageSecurity
+	Page interface{}
+}
+
+// T translates the message into the locale of the request, e.g. << .T "card.likes" 3 >>
+func (data viewData) T(key string, args ...interface{}) string {
+	return data.Locale.T(key, args...)
+}
+
+// Lang is the language tag of the page for the lang attribute
+func (data viewData) Lang() string {
+	if data.Locale == nil {
+		return ""
+	}
+	return data.Locale.Tag
+}
+
+// Locales are the choices of the language selector
+func (data viewData) Locales() []*i18n.Locale {
+	if translations == nil {
+		return nil
+	}
+	return translations.Locales()
+}
+
+// requestLocale picks the locale chosen by the user and otherwise the one preferred by the browser
+func requestLocale(r *http.Request, userSession session.Session) *i18n.Locale {
+	if translations == nil {
+		return nil
+	}
+	if userSession != nil {
+		if tag, ok := userSession.Get(localeSessionKey).(string); ok && translations.Supported(tag) {
+			return translations.Locale(tag)
+		}
+	}
+	return translations.Match(r.Header.Get("Accept-Language"))
+}
+
+// render executes the page template with the common data of the request around the view model
+func (env *environment) render(w http.ResponseWriter, r *http.Request, name string, page interface{}) {
+	env.executeTemplate(w, r, name, env.newViewData(r, page))
+}
+
+func (env *environment) newViewData(r *http.Request, page interface{}) viewData {
+	data := viewData{PageSecurity: env.pageSecurity(r), Page: page}
+	if env.sessionManager == nil {
+		data.Locale = requestLocale(r, nil)
+		return data
+	}
+	userSession, err := env.sessionManager.ReadSession(r)
+	if err != nil || userSession == nil {
+		data.Locale = requestLocale(r, nil)
+		return data
+	}
+	data.Locale = requestLocale(r, userSession)
+	data.User.FillSessionData(userSession)
+	data.CSRFToken = csrfToken(userSession)
+	data.Flashes = session.PopFlashes(userSession)
+	return data
+}
+
+// addFlash shows the message on the next rendered page, usually the one the user is redirected to.
+// The message is translated right away, the arguments are passed to T. The anonymous users have
+// no session to keep the message in, so it's dropped
+func (env *environment) addFlash(r *http.Request, level session.FlashLevel, key string, args ...interface{}) {
+	userSession, err := env.sessionManager.ReadSession(r)
+	if err != nil || userSession == nil {
+		requestLog(r).Debug("no session for flash message", "level", level, "message", key)
+		return
+	}
+	message := requestLocale(r, userSession).T(key, args...)
+	if err := session.AddFlash(userSession, level, message); err != nil {
+		requestLog(r).Warn("couldn't add flash message", "err", err)
+	}
+}
+
+// csrfToken returns the token of the session, the sessions started before the tokens were
+// introduced get one on the first use
+func csrfToken(userSession session.Session) string {
+	token, ok := userSession.Get(csrfSessionKey).(string)
+	if !ok || len(token) == 0 {
+		token = newSecureToken()
+		userSession.Set(csrfSessionKey, token)
+	}
+	return token
+}
+
+// checkCSRF rejects the state changing requests which don't carry the token of the session,
+// so other sites can't submit the forms on behalf of the logged in users
+func (env *environment) checkCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userSession, err := env.sessionManager.ReadSession(r)
+		if err != nil || userSession == nil {
+			env.renderError(w, r, http.StatusForbidden, fmt.Errorf("no session to check the CSRF token against"))
+			return
+		}
+		expected, _ := userSession.Get(csrfSessionKey).(string)
+		provided := r.Header.Get(csrfHeader)
+		if len(provided) == 0 {
+			provided = r.PostFormValue(csrfFormField)
+		}
+		if len(expected) == 0 || subtle.ConstantTimeCompare([]byte(expected), []byte(provided)) != 1 {
+			env.renderError(w, r, http.StatusForbidden, fmt.Errorf("invalid CSRF token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PostCard is the view model of the post_card partial. Post is the post of the feed, Shown is the
+// post whose text is shown, for reposts and quotes it's the reposted one
+type PostCard struct {
+	Post   *twsPost
+	Shown  *twsPost
+	Repost bool
+	Quote  bool
+	Viewer TwsUserData
+	Locale *i18n.Locale
+	// CSRFToken goes into the forms of the actions
+	CSRFToken string
+	// ReadOnly hides the actions, e.g. in the embedded posts and the previews
+	ReadOnly bool
+}
+
+// newPostCard is called by the templates with the data of the page, e.g. << postCard $ .Page.Post false >>
+func newPostCard(data viewData, post twsPost, readOnly bool) PostCard {
+	card := PostCard{Post: &post, Shown: &post, Viewer: data.User, Locale: data.Locale, CSRFToken: data.CSRFToken, ReadOnly: readOnly}
+	if post.Repost != nil && post.Type != PostType_Post {
+		card.Shown = post.Repost
+		card.Repost = post.Type == PostType_Repost
+		card.Quote = post.Type == PostType_Quote
+	}
+	return card
+}
+
+// IsOwn reports whether the viewer created the post of the feed
+func (card PostCard) IsOwn() bool {
+	return card.Viewer.IsLogged && card.Viewer.Id == card.Post.OwnerId
+}
+
+func (card PostCard) T(key string, args ...interface{}) string {
+	return card.Locale.T(key, args...)
+}
+
+// Date formats the creation date of the post for the locale, it's empty when the date is unknown
+func (card PostCard) Date(post *twsPost) string {
+	created, err := time.Parse(twsTimeFormat, post.CreationDate)
+	if err != nil {
+		return ""
+	}
+	return card.Locale.FormatTime(created)
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"github.com/matryer/is"
+	"github.com/microcosm-cc/bluemonday"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"tinywebserver/session"
+)
+
+func TestCheckCSRF(t *testing.T) {
+	is := is.New(t)
+	env := environment{
+		db:             &stubDB{},
+		sessionManager: session.NewManager("memory", "twssessionid", 3600),
+		sanitizer:      bluemonday.StrictPolicy(),
+	}
+	mux := env.routes()
+	post := func(form url.Values, header http.Header, login bool) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/save_post/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for key, values := range header {
+			req.Header.Set(key, values[0])
+		}
+		if login {
+			loginTestUser(&env, req, defaultTestUserData)
+		}
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	is.Equal(post(url.Values{"body": {"hello"}}, nil, true).Code, http.StatusForbidden)
+	is.Equal(post(url.Values{"body": {"hello"}, "csrf_token": {"forged"}}, nil, true).Code, http.StatusForbidden)
+	checkIfRedirect(post(url.Values{"body": {"hello"}, "csrf_token": {testCSRFToken}}, nil, true), "/profile/", t)
+	checkIfRedirect(post(url.Values{"body": {"hello"}}, http.Header{csrfHeader: {testCSRFToken}}, true), "/profile/", t)
+	// the anonymous requests are sent away before the token is checked
+	checkIfRedirect(post(url.Values{"body": {"hello"}, "csrf_token": {testCSRFToken}}, nil, false), "/", t)
+}
+
+func TestRenderCommonData(t *testing.T) {
+	is := is.New(t)
+	env := environment{
+		db:             &stubDB{pageData: Page{Title: "index", Body: []byte("main page")}},
+		sessionManager: session.NewManager("memory", "twssessionid", 3600),
+	}
+	mux := env.routes()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/compose_post/", nil)
+	loginTestUser(&env, req, defaultTestUserData)
+	mux.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusOK)
+	body := rec.Body.String()
+	is.True(strings.Contains(body, `name="csrf_token" value="`+testCSRFToken+`"`))
+	is.True(strings.Contains(body, `href="/logout/"`))
+	is.True(strings.Contains(body, "<title>Compose Post</title>"))
+
+	// the sessions without a token get one on the first render
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/view/index", nil)
+	req.AddCookie(&http.Cookie{Name: env.sessionManager.CookieName(), Value: "old-session"})
+	userSession := env.sessionManager.StartSession(httptest.NewRecorder(), req)
+	userSession.Set("userId", defaultTestUserData.Id)
+	mux.ServeHTTP(rec, req)
+	is.Equal(rec.Code, http.StatusOK)
+	token, _ := userSession.Get(csrfSessionKey).(string)
+	// 16 bytes of crypto/rand in base64url
+	is.Equal(len(token), 22)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/view/index", nil))
+	is.True(strings.Contains(rec.Body.String(), `href="/login/"`))
+}
+
+func TestPostCard(t *testing.T) {
+	author := twsPost{PostId: 1, Text: "original text", OwnerId: "author", OwnerName: "author", LikesCount: 2,
+		CreationDate: "2022-03-08T10:04:00.000Z"}
+	reposter := TwsUserData{Id: "reposter", IsLogged: true}
+	stranger := TwsUserData{Id: "stranger", IsLogged: true}
+	repost := twsPost{PostId: 2, OwnerId: "reposter", OwnerName: "reposter", Type: PostType_Repost, Repost: &author}
+	quote := twsPost{PostId: 3, Text: "quote text", OwnerId: "reposter", OwnerName: "reposter", Type: PostType_Quote, Repost: &author}
+	anonymized := twsPost{PostId: 4, Text: "anonymized text"}
+	liked := author
+	liked.Liked = true
+	deletedQuote := twsPost{PostId: 5, Text: "quote text", OwnerId: "reposter", OwnerName: "reposter", Type: PostType_Quote,
+		Repost: &twsPost{PostId: 1, Deleted: true}}
+
+	tests := []struct {
+		name        string
+		post        twsPost
+		viewer      TwsUserData
+		readOnly    bool
+		contains    []string
+		notContains []string
+	}{
+		{"post", author, stranger, false,
+			[]string{"original text", "/like_post/?postID=1", "/compose_post/?postID=1", "Mar 8, 2022 at 10:04 UTC", `title="2 likes"`,
+				`value="card-token"`},
+			[]string{"reposted", "/delete_post/", "tws-liked"}},
+		{"liked", liked, stranger, false,
+			[]string{"tws-liked", `href="/post/1/likes/"`},
+			nil},
+		{"own repost", repost, reposter, false,
+			[]string{"You reposted", "original text", "/delete_post/?postID=2", "/like_post/?postID=1"},
+			nil},
+		{"repost", repost, stranger, false,
+			[]string{"reposter reposted", "original text"},
+			[]string{"You reposted", "/delete_post/"}},
+		{"quote", quote, stranger, false,
+			[]string{"quote text", "tws-quoted-post", "original text"},
+			[]string{"reposted", "/compose_post/?postID"}},
+		{"anonymized", anonymized, stranger, false,
+			[]string{"Deleted account", "anonymized text", `href="#"`},
+			nil},
+		{"deleted quoted post", deletedQuote, stranger, false,
+			[]string{"quote text", "This post was deleted."},
+			[]string{"/like_post/", "original text"}},
+		{"embedded", quote, reposter, true,
+			[]string{"quote text", `href="/post/3"`},
+			[]string{"/delete_post/", "/like_post/", "/compose_post/?postID"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		err := templates.execute(&buf, "post.html", viewData{User: tt.viewer, CSRFToken: "card-token", Locale: translations.Locale("en"), Page: &PostPage{Post: tt.post}})
+		if tt.readOnly {
+			buf.Reset()
+			err = templates.execute(&buf, "post_embed.html", viewData{User: tt.viewer, Locale: translations.Locale("en"), Page: &PostPage{Post: tt.post}})
+		}
+		if err != nil {
+			t.Errorf("%v: couldn't render: %v", tt.name, err)
+			continue
+		}
+		for _, expected := range tt.contains {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("%v: expected %q in the card", tt.name, expected)
+			}
+		}
+		for _, unexpected := range tt.notContains {
+			if strings.Contains(buf.String(), unexpected) {
+				t.Errorf("%v: didn't expect %q in the card", tt.name, unexpected)
+			}
+		}
+	}
+}
+
+func TestFlashAfterRedirect(t *testing.T) {
+	is := is.New(t)
+	env := environment{
+		db:             &stubDB{},
+		sessionManager: session.NewManager("memory", "twssessionid", 3600),
+		sanitizer:      bluemonday.StrictPolicy(),
+	}
+	mux := env.routes()
+
+	form := url.Values{"body": {strings.Repeat("a", maxPostLength+1)}, "csrf_token": {testCSRFToken}}
+	req := httptest.NewRequest(http.MethodPost, "/save_post/?postID=7", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	loginTestUser(&env, req, defaultTestUserData)
+	sessionCookie, err := req.Cookie(env.sessionManager.CookieName())
+	is.NoErr(err)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	checkIfRedirect(rec, "/compose_post/?postID=7", t)
+
+	render := func() string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/compose_post/", nil)
+		req.AddCookie(sessionCookie)
+		mux.ServeHTTP(rec, req)
+		is.Equal(rec.Code, http.StatusOK)
+		return rec.Body.String()
+	}
+	body := render()
+	is.True(strings.Contains(body, `class="tws-flash tws-flash-error" role="alert"`))
+	is.True(strings.Contains(body, "Posts can&#39;t be longer than 240 characters."))
+	// the message is shown once
+	is.True(!strings.Contains(render(), "tws-flash"))
+}
+
+func TestLocales(t *testing.T) {
+	is := is.New(t)
+	db := &stubDB{pageData: Page{Title: "index", Body: []byte("main page")}}
+	env := environment{db: db, sessionManager: session.NewManager("memory", "twssessionid", 3600)}
+	mux := env.routes()
+
+	// the anonymous users get the language of the browser
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/view/index", nil)
+	req.Header.Set("Accept-Language", "uk-UA,uk;q=0.9,en;q=0.8")
+	mux.ServeHTTP(rec, req)
+	is.True(strings.Contains(rec.Body.String(), `<html lang="uk">`))
+	is.True(strings.Contains(rec.Body.String(), "Увійти"))
+
+	req = httptest.NewRequest(http.MethodGet, "/view/index", nil)
+	loginTestUser(&env, req, defaultTestUserData)
+	sessionCookie, err := req.Cookie(env.sessionManager.CookieName())
+	is.NoErr(err)
+	setLocale := func(locale string) *httptest.ResponseRecorder {
+		form := url.Values{"locale": {locale}, "csrf_token": {testCSRFToken}}
+		req := httptest.NewRequest(http.MethodPost, "/locale/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", "http://example.com/view/index")
+		req.AddCookie(sessionCookie)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	render := func() string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/view/index", nil)
+		req.Header.Set("Accept-Language", "en")
+		req.AddCookie(sessionCookie)
+		mux.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	is.Equal(setLocale("de").Code, http.StatusBadRequest)
+	checkIfRedirect(setLocale("uk"), "/view/index", t)
+	is.Equal(db.userLocale, "uk")
+	// the choice of the user wins over the browser
+	body := render()
+	is.True(strings.Contains(body, "Мову змінено."))
+	is.True(strings.Contains(body, `<option value="uk" selected>Українська</option>`))
+
+	checkIfRedirect(setLocale(""), "/view/index", t)
+	is.Equal(db.userLocale, "")
+	is.True(strings.Contains(render(), `<html lang="en">`))
+}
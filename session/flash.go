@@ -0,0 +1,33 @@
+package session
+
+// FlashLevel tells how the flash message is presented to the user
+type FlashLevel string
+
+const (
+	FlashSuccess FlashLevel = "success"
+	FlashWarning FlashLevel = "warning"
+	FlashError   FlashLevel = "error"
+)
+
+// Flash is a message set by one request and shown by the next one, e.g. after a redirect
+type Flash struct {
+	Level   FlashLevel
+	Message string
+}
+
+const flashesKey = "flashes"
+
+// AddFlash queues the message in the session until PopFlashes is called
+func AddFlash(session Session, level FlashLevel, message string) error {
+	flashes, _ := session.Get(flashesKey).([]Flash)
+	return session.Set(flashesKey, append(flashes, Flash{Level: level, Message: message}))
+}
+
+// PopFlashes returns the queued messages in the order they were added and removes them from the session
+func PopFlashes(session Session) []Flash {
+	flashes, _ := session.Get(flashesKey).([]Flash)
+	if len(flashes) > 0 {
+		session.Delete(flashesKey)
+	}
+	return flashes
+}
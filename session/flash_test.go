@@ -0,0 +1,23 @@
+package session
+
+import (
+	"github.com/matryer/is"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFlashes(t *testing.T) {
+	is := is.New(t)
+	sessionManager := NewManager("memory", testCookieName, testMaxSessionLifeTime)
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	session := sessionManager.StartSession(httptest.NewRecorder(), req)
+
+	is.Equal(len(PopFlashes(session)), 0)
+	is.NoErr(AddFlash(session, FlashError, "first"))
+	is.NoErr(AddFlash(session, FlashSuccess, "second"))
+	is.Equal(PopFlashes(session), []Flash{{FlashError, "first"}, {FlashSuccess, "second"}})
+
+	// the messages are shown only once
+	is.Equal(len(PopFlashes(session)), 0)
+}
@@ -5,6 +5,7 @@ import (
 	"net/url"
 	"sync"
 	"time"
+	"tinywebserver/logger"
 	"tinywebserver/utils"
 )
 
@@ -29,6 +30,24 @@ type Manager struct {
 	lock sync.Mutex
 	provider PersistenceProvider
 	maxLifetime int64
+	gcTimer *time.Timer
+	gcStopped bool
+	secureCookies bool
+}
+
+func (manager *Manager) CookieName() string {
+	return manager.cookieName
+}
+
+// SetSecureCookies marks the session cookie as Secure, so browsers send it only over HTTPS
+func (manager *Manager) SetSecureCookies(secure bool) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.secureCookies = secure
+}
+
+func (manager *Manager) SessionCount() int {
+	return manager.provider.SessionCount()
 }
 
 func (manager *Manager) StartSession(w http.ResponseWriter, r *http.Request) (session Session) {
@@ -39,7 +58,7 @@ func (manager *Manager) StartSession(w http.ResponseWriter, r *http.Request) (se
 	if err != nil || cookie.Value == "" {
 		sid := utils.RandString(32)
 		session, _ = manager.provider.SessionInit(sid)
-		cookie := http.Cookie{Name: manager.cookieName, Value: url.QueryEscape(sid), Path: "/", HttpOnly: true, MaxAge: int(manager.maxLifetime)}
+		cookie := http.Cookie{Name: manager.cookieName, Value: url.QueryEscape(sid), Path: "/", HttpOnly: true, Secure: manager.secureCookies, MaxAge: int(manager.maxLifetime)}
 		http.SetCookie(w, &cookie)
 	} else {
 		sid, _ := url.QueryUnescape(cookie.Value)
@@ -68,7 +87,7 @@ func (manager *Manager) DestroySession(w http.ResponseWriter, r *http.Request) {
 
 		manager.provider.SessionDestroy(cookie.Value)
 		expiration := time.Now()
-		cookie := http.Cookie{Name: manager.cookieName, Path: "/", HttpOnly: true, Expires: expiration}
+		cookie := http.Cookie{Name: manager.cookieName, Path: "/", HttpOnly: true, Secure: manager.secureCookies, Expires: expiration}
 		http.SetCookie(w, &cookie)
 	}
 }
@@ -76,11 +95,29 @@ func (manager *Manager) DestroySession(w http.ResponseWriter, r *http.Request) {
 func (manager *Manager) StartGC() {
 	manager.lock.Lock()
 	defer manager.lock.Unlock()
+	if manager.gcStopped {
+		return
+	}
 
 	manager.provider.SessionGC(manager.maxLifetime)
-	time.AfterFunc(time.Duration(manager.maxLifetime), func() { manager.StartGC() })
+	sessionLog.Debug("session gc finished", "sessions", manager.provider.SessionCount())
+	manager.gcTimer = time.AfterFunc(time.Duration(manager.maxLifetime)*time.Second, func() { manager.StartGC() })
+}
+
+// StopGC stops the periodic session garbage collection started by StartGC
+func (manager *Manager) StopGC() {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	manager.gcStopped = true
+	if manager.gcTimer != nil {
+		manager.gcTimer.Stop()
+		manager.gcTimer = nil
+	}
 }
 
+var sessionLog = logger.New("session")
+
 var providers = make(map[string]PersistenceProvider)
 
 func NewManager(providerName, cookieName string, maxLifetime int64) *Manager {
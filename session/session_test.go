@@ -152,4 +152,31 @@ func TestRegister(t *testing.T) {
 	anotherTestProviderName := "test_provider_name"
 	Register(anotherTestProviderName, anotherTestProvider)
 	is.Equal(providers[anotherTestProviderName], anotherTestProvider)
-}
\ No newline at end of file
+}
+
+func TestStopGC(t *testing.T) {
+	is := is.New(t)
+
+	manager := NewManager(testProviderName, testCookieName, testMaxSessionLifeTime)
+	manager.StartGC()
+	is.True(manager.gcTimer != nil)
+
+	manager.StopGC()
+	is.True(manager.gcTimer == nil)
+	manager.StartGC()
+	is.True(manager.gcTimer == nil)
+}
+
+func TestSecureCookies(t *testing.T) {
+	is := is.New(t)
+
+	sessionManager := NewManager(testProviderName, testCookieName, testMaxSessionLifeTime)
+	for _, secure := range []bool{false, true} {
+		sessionManager.SetSecureCookies(secure)
+		rec := httptest.NewRecorder()
+		_ = sessionManager.StartSession(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		cookies := rec.Result().Cookies()
+		is.Equal(len(cookies), 1)
+		is.Equal(cookies[0].Secure, secure)
+	}
+}
@@ -119,6 +119,9 @@ func (pdr *Provider) SessionUpdate(sid string) error {
 }
 
 func (pdr *Provider) SessionCount() int {
+	pdr.lock.Lock()
+	defer pdr.lock.Unlock()
+
 	return pdr.list.Len()
 }
 